@@ -0,0 +1,29 @@
+package otelchi
+
+import (
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys used in the message events, see `WithMessageEvents`.
+const (
+	ReadBytesKey  = attribute.Key("http.read_bytes")  // if anything was read from the request body, the total number of bytes read
+	ReadErrorKey  = attribute.Key("http.read_error")  // if an error occurred while reading a request, the string of the error (io.EOF is not recorded)
+	WroteBytesKey = attribute.Key("http.wrote_bytes") // if anything was written to the response writer, the total number of bytes written
+	WriteErrorKey = attribute.Key("http.write_error") // if an error occurred while writing a reply, the string of the error (io.EOF is not recorded)
+)
+
+// addReadEvent records read event on the span, the empty read at the end
+// of the body is not recorded.
+func addReadEvent(span oteltrace.Span, n int, err error) {
+	if n == 0 && (err == nil || err == io.EOF) {
+		return
+	}
+	attrs := []attribute.KeyValue{ReadBytesKey.Int64(int64(n))}
+	if err != nil && err != io.EOF {
+		attrs = append(attrs, ReadErrorKey.String(err.Error()))
+	}
+	span.AddEvent("read", oteltrace.WithAttributes(attrs...))
+}
@@ -2,8 +2,14 @@ package otelchi
 
 import (
 	"net/http"
+	"net/netip"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/internal/chiroute"
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	"github.com/riandyrn/otelchi/internal/semconvutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/propagation"
 	oteltrace "go.opentelemetry.io/otel/trace"
 )
@@ -14,6 +20,10 @@ const (
 	DefaultTraceSampledResponseHeaderKey = "X-Trace-Sampled"
 )
 
+// TraceResponseHeaderKey is the header key of W3C Trace Context
+// `traceresponse` header written when `WithTraceResponse` is used.
+const TraceResponseHeaderKey = "traceresponse"
+
 // config is used to configure the mux middleware.
 type config struct {
 	tracerProvider                oteltrace.TracerProvider
@@ -21,9 +31,24 @@ type config struct {
 	chiRoutes                     chi.Routes
 	requestMethodInSpanName       bool
 	filters                       []Filter
+	routeFilters                  []RouteFilter
 	traceIDResponseHeaderKey      string
 	traceSampledResponseHeaderKey string
 	publicEndpointFn              func(r *http.Request) bool
+	semconvMode                   SemConvStabilityMode
+	panicRecording                bool
+	requestHeaders                []capturedHeader
+	responseHeaders               []capturedHeader
+	spanNameFormatter             SpanNameFormatter
+	attributesFunc                func(r *http.Request) []attribute.KeyValue
+	endAttributesFunc             func(r *http.Request, statusCode int) []attribute.KeyValue
+	traceResponse                 *TraceResponseConfig
+	readEvent                     bool
+	writeEvent                    bool
+	trustedProxies                []netip.Prefix
+	statusClassifier              StatusClassifier
+	unmatchedSpanNameFn           func(r *http.Request) string
+	abortedStatusCodes            map[string]int
 }
 
 // Option specifies instrumentation configuration options.
@@ -41,6 +66,13 @@ func (o optionFunc) apply(c *config) {
 // be traced. A Filter must return true if the request should be traced.
 type Filter func(*http.Request) bool
 
+// RouteFilter is a predicate used to determine whether a given http.Request
+// should be traced based on the request & its matched chi route pattern. A
+// RouteFilter must return true if the request should be traced.
+//
+// See package `github.com/riandyrn/otelchi/filters` for ready-made filters.
+type RouteFilter func(r *http.Request, routePattern string) bool
+
 // WithPropagators specifies propagators to use for extracting
 // information from the HTTP requests. If none are specified, global
 // ones will be used.
@@ -85,6 +117,23 @@ func WithRequestMethodInSpanName(isActive bool) Option {
 	})
 }
 
+// SpanNameFormatter is used for generating the span name from the incoming
+// request & its chi route pattern. The route pattern is an empty string when
+// no route matches the request.
+type SpanNameFormatter func(r *http.Request, routePattern string) string
+
+// WithSpanNameFormatter specifies a function for generating the span name. It
+// is called during span creation when the route pattern could be resolved
+// using `WithChiRoutes`, otherwise it is called at the end of the request.
+//
+// When this option is set, `WithRequestMethodInSpanName` is ignored since the
+// formatter has the full control over the span name.
+func WithSpanNameFormatter(fn SpanNameFormatter) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.spanNameFormatter = fn
+	})
+}
+
 // WithFilter adds a filter to the list of filters used by the handler.
 // If any filter indicates to exclude a request then the request will not be
 // traced. All filters must allow a request to be traced for a Span to be created.
@@ -97,6 +146,20 @@ func WithFilter(filter Filter) Option {
 	})
 }
 
+// WithRouteFilter adds a route filter to the list of route filters used by
+// the handler. Route filters are evaluated after the request has been matched
+// against the routes set in `WithChiRoutes`, so they receive the matched
+// route pattern. Without `WithChiRoutes`, or when no route matches the
+// request, the route pattern is an empty string.
+//
+// Just like `WithFilter`, all route filters must allow a request to be traced
+// for a Span to be created.
+func WithRouteFilter(filter RouteFilter) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.routeFilters = append(cfg.routeFilters, filter)
+	})
+}
+
 // WithTraceIDResponseHeader enables adding trace id into response header.
 // It accepts a function that generates the header key name. If this parameter
 // function set to `nil` the default header key which is `X-Trace-Id` will be used.
@@ -137,6 +200,29 @@ func WithTraceResponseHeaders(cfg TraceHeaderConfig) Option {
 	})
 }
 
+// TraceResponseConfig is configuration for W3C Trace Context `traceresponse`
+// header in the response.
+type TraceResponseConfig struct {
+	// Propagator, if set, is used to inject the server span context into the
+	// response headers as well, this is useful for legacy clients that expect
+	// other formats such as B3.
+	Propagator propagation.TextMapPropagator
+	// ExposeHeaders, if set to true, adds the names of the trace headers
+	// written by the middleware into `Access-Control-Expose-Headers` so
+	// browser clients are able to read them.
+	ExposeHeaders bool
+}
+
+// WithTraceResponse enables writing W3C Trace Context `traceresponse` header
+// (`version-traceid-spanid-flags`) of the server span into the response.
+//
+// See https://w3c.github.io/trace-context/#traceresponse-header for details.
+func WithTraceResponse(cfg TraceResponseConfig) Option {
+	return optionFunc(func(c *config) {
+		c.traceResponse = &cfg
+	})
+}
+
 // WithPublicEndpoint is used for marking every endpoint as public endpoint.
 // This means if the incoming request has span context, it won't be used as
 // parent span by the span generated by this middleware, instead the generated
@@ -177,3 +263,224 @@ func WithPublicEndpointFn(fn func(r *http.Request) bool) Option {
 		cfg.publicEndpointFn = fn
 	})
 }
+
+// WithPanicRecording is used for recording panic raised by the underlying
+// handler. When active, the panic is recovered, recorded as `exception` event
+// along with its stack trace & type, then the span is marked as error with
+// status code 500. After that the panic is raised again so it could still be
+// handled by upstream recoverer such as `middleware.Recoverer` from chi.
+// The `http.ErrAbortHandler` panic is re-raised without being recorded since
+// it only aborts the response.
+func WithPanicRecording(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.panicRecording = isActive
+	})
+}
+
+// WithRequestHeaders specifies the allowlist of request headers that are
+// recorded as `http.request.header.<name>` span attributes. The header names
+// are case-insensitive.
+//
+// Values of sensitive headers such as `Authorization`, `Proxy-Authorization`
+// & `Cookie` are always recorded as `[REDACTED]`.
+func WithRequestHeaders(headers ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.requestHeaders = append(cfg.requestHeaders, newCapturedHeaders("http.request.header.", headers)...)
+	})
+}
+
+// WithResponseHeaders specifies the allowlist of response headers that are
+// recorded as `http.response.header.<name>` span attributes. The header
+// names are case-insensitive. The headers are captured once the underlying
+// handler writes the response header, or after the handler returns when it
+// writes nothing.
+//
+// Values of sensitive headers such as `Set-Cookie` are always recorded as
+// `[REDACTED]`.
+func WithResponseHeaders(headers ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.responseHeaders = append(cfg.responseHeaders, newCapturedHeaders("http.response.header.", headers)...)
+	})
+}
+
+// WithAttributesFunc specifies a function called during span creation to
+// add attributes derived from the request. Since the attributes are known
+// when the span starts, they are visible to the sampler.
+func WithAttributesFunc(fn func(r *http.Request) []attribute.KeyValue) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.attributesFunc = fn
+	})
+}
+
+// WithEndAttributesFunc specifies a function called after the underlying
+// handler returns to add attributes at the end of the request. The function
+// receives the response status code recorded by the middleware.
+func WithEndAttributesFunc(fn func(r *http.Request, statusCode int) []attribute.KeyValue) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.endAttributesFunc = fn
+	})
+}
+
+// Event represents message event types for `WithMessageEvents`.
+type Event int
+
+// Different types of events that can be recorded, see `WithMessageEvents`.
+const (
+	ReadEvents Event = iota
+	WriteEvents
+)
+
+// WithMessageEvents configures the middleware to record the specified events
+// (span.AddEvent) on the server span. By default only summary attributes
+// are added at the end of the request.
+//
+// There are 2 types of events that can be recorded:
+//
+//   - ReadEvents: Record the number of bytes read after every
+//     `http.Request.Body.Read` using the `http.read_bytes` attribute.
+//   - WriteEvents: Record the number of bytes written after every
+//     `http.ResponseWriter.Write` using the `http.wrote_bytes` attribute.
+//     The `first_byte_written` event is recorded as well, its timestamp
+//     marks the time to first byte of the response.
+func WithMessageEvents(events ...Event) Option {
+	return optionFunc(func(cfg *config) {
+		for _, e := range events {
+			switch e {
+			case ReadEvents:
+				cfg.readEvent = true
+			case WriteEvents:
+				cfg.writeEvent = true
+			}
+		}
+	})
+}
+
+// WithTrustedProxies specifies CIDRs of the proxies (e.g load balancers) in
+// front of the server. When the request comes from a trusted proxy, the
+// client address, scheme & server address attributes are derived from the
+// `Forwarded` or `X-Forwarded-For`, `X-Forwarded-Proto` & `X-Forwarded-Host`
+// headers. Hops that are not trusted are never used, so the headers can't be
+// spoofed by the client. The server name given to `Middleware` still takes
+// precedence over the forwarded host.
+//
+// Use the same CIDRs for `metric.WithTrustedProxies` so spans & metrics
+// agree with each other.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.trustedProxies = append(cfg.trustedProxies, prefixes...)
+	})
+}
+
+// SemConvStabilityMode determines which version of the HTTP semantic
+// conventions is emitted by the middleware.
+type SemConvStabilityMode = semconvutil.Mode
+
+const (
+	// SemConvStabilityModeDefault reads the mode from the
+	// `OTEL_SEMCONV_STABILITY_OPT_IN` environment variable. When it is set to
+	// `http` only the stable attributes are emitted, when it is set to
+	// `http/dup` both versions are emitted, otherwise the old attributes are
+	// emitted.
+	SemConvStabilityModeDefault = semconvutil.ModeDefault
+	// SemConvStabilityModeOld emits attributes from semconv v1.20.0 such as
+	// `http.method`, `http.status_code` & `net.host.name`.
+	SemConvStabilityModeOld = semconvutil.ModeOld
+	// SemConvStabilityModeStable emits attributes from the stable semconv
+	// v1.26.0 such as `http.request.method`, `http.response.status_code`,
+	// `server.address` & `url.path`.
+	SemConvStabilityModeStable = semconvutil.ModeStable
+	// SemConvStabilityModeDup emits both the old and the stable attributes.
+	SemConvStabilityModeDup = semconvutil.ModeDup
+)
+
+// WithSemConvStabilityMode specifies which version of the HTTP semantic
+// conventions is emitted on the spans. This is useful for migrating
+// dashboards & alerts to the stable conventions without a flag day, first
+// emit both versions using `SemConvStabilityModeDup`, then switch to
+// `SemConvStabilityModeStable` once everything has been migrated.
+//
+// If this option is not set, the mode is read from the
+// `OTEL_SEMCONV_STABILITY_OPT_IN` environment variable.
+func WithSemConvStabilityMode(mode SemConvStabilityMode) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.semconvMode = mode
+	})
+}
+
+// StatusClassifier classifies the response status code into the span status,
+// the returned description is only used when the code is `codes.Error`. See
+// `DefaultStatusClassifier` for the ready-made classifiers.
+type StatusClassifier func(r *http.Request, status int) (codes.Code, string)
+
+// WithStatusClassifier specifies the classifier used for setting the span
+// status from the response status code, e.g for treating 409 & 422 responses
+// as errors or 503 load shedding responses as not errors.
+//
+// If this option is not set, `DefaultStatusClassifier` is used.
+func WithStatusClassifier(classifier StatusClassifier) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.statusClassifier = classifier
+	})
+}
+
+// UnmatchedRouteKey is the span attribute key set when the request doesn't
+// match any chi route, the value is either `UnmatchedRouteNotFound` or
+// `UnmatchedRouteMethodNotAllowed`. The `http.route` attribute is omitted for
+// such requests.
+const UnmatchedRouteKey = chiroute.UnmatchedKey
+
+// Values of `UnmatchedRouteKey`.
+const (
+	UnmatchedRouteNotFound         = chiroute.NotFound
+	UnmatchedRouteMethodNotAllowed = chiroute.MethodNotAllowed
+)
+
+// WithUnmatchedSpanName specifies the function used for naming the span of
+// the request which doesn't match any chi route, i.e the request handled by
+// chi NotFound or MethodNotAllowed handler.
+//
+// If this option is not set, the span name formatter set by
+// `WithSpanNameFormatter` is called using empty route pattern, otherwise the
+// span is named `HTTP <METHOD>`, e.g `HTTP GET`.
+func WithUnmatchedSpanName(fn func(r *http.Request) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.unmatchedSpanNameFn = fn
+	})
+}
+
+// Attribute keys used for the aborted requests.
+const (
+	// AbortedKey is the span attribute key set when the request context is
+	// done when the handler returns, the value is either
+	// `AbortedClientCancelled` or `AbortedDeadlineExceeded`.
+	AbortedKey = requeststate.AbortedKey
+	// AbortedElapsedKey is the attribute of `request_aborted` event containing
+	// the time elapsed since the request started, in seconds.
+	AbortedElapsedKey = attribute.Key("http.request.elapsed")
+)
+
+// Values of `AbortedKey`.
+const (
+	AbortedClientCancelled  = requeststate.ClientCancelled
+	AbortedDeadlineExceeded = requeststate.DeadlineExceeded
+)
+
+// WithAbortedStatusCodes specifies the status codes used for the span when
+// the request is cancelled by the client or exceeds its deadline (e.g set by
+// chi `middleware.Timeout` registered before this middleware), e.g 499 &
+// 504. The status code is used for the status code attribute, the status
+// classifier & the metrics recorded by `NewInstrumentation`. Use 0 for
+// keeping the status code written by the handler, which is also the behavior
+// when this option is not set.
+//
+// The default status classifier treats the status codes which are not
+// defined by the HTTP specification (e.g 499) as error, use
+// `UnsetStatusClassifier` for leaving them unset.
+func WithAbortedStatusCodes(clientCancelled, deadlineExceeded int) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.abortedStatusCodes = map[string]int{
+			AbortedClientCancelled:  clientCancelled,
+			AbortedDeadlineExceeded: deadlineExceeded,
+		}
+	})
+}
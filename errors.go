@@ -0,0 +1,26 @@
+package otelchi
+
+import (
+	"net/http"
+
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// RecordError records err as `exception` event on the server span of the
+// request & remembers it until the end of the request. When the response is
+// classified as error by the status classifier, the error message is used as
+// the span status description. The `error.type` attribute is set on both the
+// span & the metric records of the request.
+//
+// It is safe to call it when the request is not traced, the error is still
+// recorded on the metrics when the metric middlewares are used.
+func RecordError(r *http.Request, err error, opts ...oteltrace.EventOption) {
+	if err == nil {
+		return
+	}
+	oteltrace.SpanFromContext(r.Context()).RecordError(err, opts...)
+	if state := requeststate.FromContext(r.Context()); state != nil {
+		state.SetError(err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package filters provides ready-made route filters to be used with
+// `otelchi.WithRouteFilter`.
+//
+// Every filter returns true when the request matches it, so in order to
+// exclude the matching requests from being traced, wrap the filter with
+// `Not`, for example:
+//
+//	otelchi.WithRouteFilter(filters.Not(filters.Route("/health", "/users/*/avatar")))
+package filters
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/riandyrn/otelchi"
+)
+
+// Route returns a RouteFilter that returns true when the matched route
+// pattern equals or matches any of the given glob patterns. The glob syntax
+// is the one used by `path.Match`, e.g `/users/*/avatar` matches the
+// `/users/{id}/avatar` route pattern. Requests without matched route never
+// pass this filter.
+func Route(patterns ...string) otelchi.RouteFilter {
+	return func(r *http.Request, routePattern string) bool {
+		if routePattern == "" {
+			return false
+		}
+		for _, pattern := range patterns {
+			if pattern == routePattern {
+				return true
+			}
+			if ok, err := path.Match(pattern, routePattern); err == nil && ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Method returns a RouteFilter that returns true when the request method is
+// any of the given methods. The comparison is case-insensitive.
+func Method(methods ...string) otelchi.RouteFilter {
+	return func(r *http.Request, _ string) bool {
+		for _, method := range methods {
+			if strings.EqualFold(r.Method, method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Header returns a RouteFilter that returns true when the request contains
+// the given header, regardless of its value.
+func Header(name string) otelchi.RouteFilter {
+	return func(r *http.Request, _ string) bool {
+		return len(r.Header.Values(name)) > 0
+	}
+}
+
+// UserAgent returns a RouteFilter that returns true when the request user
+// agent contains any of the given substrings. The comparison is
+// case-insensitive, this is useful for filtering health checkers & bots.
+func UserAgent(substrs ...string) otelchi.RouteFilter {
+	return func(r *http.Request, _ string) bool {
+		ua := strings.ToLower(r.UserAgent())
+		for _, substr := range substrs {
+			if strings.Contains(ua, strings.ToLower(substr)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a RouteFilter that returns the opposite of the given filter.
+func Not(f otelchi.RouteFilter) otelchi.RouteFilter {
+	return func(r *http.Request, routePattern string) bool {
+		return !f(r, routePattern)
+	}
+}
+
+// Any returns a RouteFilter that returns true when any of the given filters
+// returns true.
+func Any(fs ...otelchi.RouteFilter) otelchi.RouteFilter {
+	return func(r *http.Request, routePattern string) bool {
+		for _, f := range fs {
+			if f(r, routePattern) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All returns a RouteFilter that returns true only when all of the given
+// filters return true.
+func All(fs ...otelchi.RouteFilter) otelchi.RouteFilter {
+	return func(r *http.Request, routePattern string) bool {
+		for _, f := range fs {
+			if !f(r, routePattern) {
+				return false
+			}
+		}
+		return true
+	}
+}
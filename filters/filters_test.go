@@ -0,0 +1,117 @@
+package filters_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/riandyrn/otelchi"
+	"github.com/riandyrn/otelchi/filters"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilters(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name         string
+		Filter       otelchi.RouteFilter
+		Method       string
+		Headers      map[string]string
+		RoutePattern string
+		Expected     bool
+	}{
+		{
+			Name:         "Route Exact Match",
+			Filter:       filters.Route("/users/{id:[0-9]+}"),
+			RoutePattern: "/users/{id:[0-9]+}",
+			Expected:     true,
+		},
+		{
+			Name:         "Route Glob Match",
+			Filter:       filters.Route("/health", "/users/*/avatar"),
+			RoutePattern: "/users/{id}/avatar",
+			Expected:     true,
+		},
+		{
+			Name:         "Route No Match",
+			Filter:       filters.Route("/users/*/avatar"),
+			RoutePattern: "/users/{id}",
+			Expected:     false,
+		},
+		{
+			Name:         "Route Unmatched Request",
+			Filter:       filters.Route("*"),
+			RoutePattern: "",
+			Expected:     false,
+		},
+		{
+			Name:     "Method Match",
+			Filter:   filters.Method("get", "HEAD"),
+			Method:   "GET",
+			Expected: true,
+		},
+		{
+			Name:     "Method No Match",
+			Filter:   filters.Method("GET"),
+			Method:   "POST",
+			Expected: false,
+		},
+		{
+			Name:     "Header Present",
+			Filter:   filters.Header("x-debug"),
+			Headers:  map[string]string{"X-Debug": ""},
+			Expected: true,
+		},
+		{
+			Name:     "Header Absent",
+			Filter:   filters.Header("X-Debug"),
+			Expected: false,
+		},
+		{
+			Name:     "User Agent Match",
+			Filter:   filters.UserAgent("kube-probe", "googlebot"),
+			Headers:  map[string]string{"User-Agent": "Mozilla/5.0 (compatible; Googlebot/2.1)"},
+			Expected: true,
+		},
+		{
+			Name:     "User Agent No Match",
+			Filter:   filters.UserAgent("kube-probe"),
+			Headers:  map[string]string{"User-Agent": "curl/8.0"},
+			Expected: false,
+		},
+		{
+			Name:         "Not",
+			Filter:       filters.Not(filters.Route("/health")),
+			RoutePattern: "/health",
+			Expected:     false,
+		},
+		{
+			Name:         "Any",
+			Filter:       filters.Any(filters.Route("/health"), filters.Method("OPTIONS")),
+			Method:       "OPTIONS",
+			RoutePattern: "/users",
+			Expected:     true,
+		},
+		{
+			Name:         "All",
+			Filter:       filters.All(filters.Route("/users"), filters.Method("POST")),
+			Method:       "GET",
+			RoutePattern: "/users",
+			Expected:     false,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			method := testCase.Method
+			if method == "" {
+				method = "GET"
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			for key, val := range testCase.Headers {
+				r.Header.Set(key, val)
+			}
+			assert.Equal(t, testCase.Expected, testCase.Filter(r, testCase.RoutePattern))
+		})
+	}
+}
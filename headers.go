@@ -0,0 +1,70 @@
+package otelchi
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RedactedHeaderValue is the value recorded in place of the actual value of
+// sensitive headers.
+const RedactedHeaderValue = "[REDACTED]"
+
+// sensitiveHeaders contains headers that may carry credentials, the values
+// of these headers are never recorded as span attributes.
+var sensitiveHeaders = map[string]struct{}{
+	"Authorization":       {},
+	"Proxy-Authorization": {},
+	"Cookie":              {},
+	"Set-Cookie":          {},
+}
+
+// capturedHeader is a header allowed to be recorded as span attribute.
+type capturedHeader struct {
+	name      string        // canonical header name
+	key       attribute.Key // e.g `http.request.header.content-type`
+	sensitive bool
+}
+
+func newCapturedHeaders(prefix string, names []string) []capturedHeader {
+	headers := make([]capturedHeader, 0, len(names))
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		_, sensitive := sensitiveHeaders[name]
+		headers = append(headers, capturedHeader{
+			name:      name,
+			key:       attribute.Key(prefix + strings.ToLower(name)),
+			sensitive: sensitive,
+		})
+	}
+	return headers
+}
+
+// headerAttributes returns the values of the allowlisted headers as string
+// slice attributes, headers that are not present are skipped.
+func headerAttributes(h http.Header, headers []capturedHeader) []attribute.KeyValue {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	var attrs []attribute.KeyValue
+	for _, header := range headers {
+		values := h.Values(header.name)
+		if len(values) == 0 {
+			continue
+		}
+		if header.sensitive {
+			redacted := make([]string, len(values))
+			for i := range redacted {
+				redacted[i] = RedactedHeaderValue
+			}
+			values = redacted
+		}
+		attrs = append(attrs, header.key.StringSlice(values))
+	}
+	return attrs
+}
@@ -0,0 +1,47 @@
+package otelchi
+
+import (
+	"net/http"
+
+	"github.com/riandyrn/otelchi/metric"
+)
+
+// NewInstrumentation sets up a handler to trace the incoming requests & to
+// record the metrics of them at once. It is equivalent to stacking
+// `Middleware`, `metric.NewRequestDurationMillis`, `metric.NewRequestInFlight`,
+// `metric.NewResponseSizeBytes`, `metric.NewRequestBodySize`,
+// `metric.NewRequestsAborted` & `metric.NewRequestsTotal`, but cheaper per
+// request since the response writer is only wrapped once, the route is only
+// resolved once & the start time is shared by the span & the metrics.
+//
+// The serverName parameter should describe the name of the (virtual) server
+// handling the request, it is used for both traces & metrics. The traceOpts
+// are the options of `Middleware` while the metricOpts are the options of
+// `metric.NewBaseConfig`. The `WithChiRoutes`, `WithSemConvStabilityMode` &
+// `WithTrustedProxies` options in traceOpts are used by the metrics as well,
+// so the spans & the metrics agree with each other. The routes & the mode are
+// overridden by the same options in metricOpts, while the trusted proxies are
+// combined.
+func NewInstrumentation(serverName string, traceOpts []Option, metricOpts []metric.Option) func(next http.Handler) http.Handler {
+	cfg := config{}
+	for _, opt := range traceOpts {
+		opt.apply(&cfg)
+	}
+
+	// the options derived from traceOpts are prepended so metricOpts take
+	// precedence
+	sharedOpts := []metric.Option{}
+	if cfg.chiRoutes != nil {
+		sharedOpts = append(sharedOpts, metric.WithChiRoutes(cfg.chiRoutes))
+	}
+	if cfg.semconvMode != SemConvStabilityModeDefault {
+		sharedOpts = append(sharedOpts, metric.WithSemConvStabilityMode(cfg.semconvMode))
+	}
+	if len(cfg.trustedProxies) > 0 {
+		sharedOpts = append(sharedOpts, metric.WithTrustedProxies(cfg.trustedProxies...))
+	}
+	metricOpts = append(sharedOpts, metricOpts...)
+
+	metrics := metric.NewRecorder(metric.NewBaseConfig(serverName, metricOpts...))
+	return newMiddleware(serverName, metrics, traceOpts...)
+}
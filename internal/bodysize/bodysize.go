@@ -0,0 +1,70 @@
+// Package bodysize measures the size of request & response bodies, it is
+// shared by the middleware & the metric package so both agree on the sizes.
+package bodysize
+
+import (
+	"io"
+	"net/http"
+)
+
+// Reader wraps request body for counting the number of bytes actually read
+// by the handler.
+type Reader struct {
+	io.ReadCloser
+	read   int64
+	onRead func(n int, err error)
+}
+
+// Wrap returns a shallow copy of the request whose body is wrapped by Reader,
+// so the caller's request is never modified. The onRead callback is called
+// after every read when it is not nil. When the request has no body, the
+// request is returned as is along with nil Reader.
+func Wrap(r *http.Request, onRead func(n int, err error)) (*http.Request, *Reader) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	body := &Reader{ReadCloser: r.Body, onRead: onRead}
+	req := *r
+	req.Body = body
+	return &req, body
+}
+
+func (body *Reader) Read(b []byte) (int, error) {
+	n, err := body.ReadCloser.Read(b)
+	body.read += int64(n)
+	if body.onRead != nil {
+		body.onRead(n, err)
+	}
+	return n, err
+}
+
+// Request returns the size of the request body for the span, the
+// `Content-Length` is used when it is known since the handler may stop
+// reading early, e.g when the body is too large. The number of bytes read is
+// used for the body with unknown length, e.g chunked body. It returns false
+// when nothing was read & no `Content-Length` was sent.
+func Request(r *http.Request, body *Reader) (int64, bool) {
+	if r.ContentLength > 0 {
+		return r.ContentLength, true
+	}
+	if body != nil && body.read > 0 {
+		return body.read, true
+	}
+	return 0, r.Header.Get("Content-Length") != ""
+}
+
+// Consumed returns the number of bytes read from the request body, when the
+// handler doesn't read the body the `Content-Length` is used. It returns
+// false when nothing was read & no `Content-Length` was sent.
+func Consumed(r *http.Request, body *Reader) (int64, bool) {
+	if body != nil && body.read > 0 {
+		return body.read, true
+	}
+	return Request(r, body)
+}
+
+// Response returns the number of bytes written into the response body. It
+// returns false when nothing was written & no `Content-Length` was sent.
+func Response(h http.Header, written int64) (int64, bool) {
+	return written, written > 0 || h.Get("Content-Length") != ""
+}
@@ -0,0 +1,120 @@
+package bodysize
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrap(t *testing.T) {
+	// the request without body is not wrapped
+	r := httptest.NewRequest("GET", "/", nil)
+	wrapped, body := Wrap(r, nil)
+	assert.Same(t, r, wrapped)
+	assert.Nil(t, body)
+
+	// the body of the copied request is wrapped
+	reads := []int{}
+	r = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	wrapped, body = Wrap(r, func(n int, err error) {
+		reads = append(reads, n)
+	})
+	require.NotNil(t, body)
+	assert.NotSame(t, r, wrapped)
+	assert.NotEqual(t, r.Body, wrapped.Body)
+
+	_, err := io.ReadAll(wrapped.Body)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 0}, reads)
+}
+
+func TestRequest(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name          string
+		Body          string
+		ContentLength int64
+		Read          int64
+		ExpSize       int64
+		ExpConsumed   int64
+		ExpOK         bool
+	}{
+		{
+			Name: "No Body",
+		},
+		{
+			Name:  "Empty Body With Content-Length",
+			ExpOK: true,
+		},
+		{
+			Name:          "Unread Body",
+			Body:          "hello",
+			ContentLength: 5,
+			ExpSize:       5,
+			ExpConsumed:   5,
+			ExpOK:         true,
+		},
+		{
+			Name:          "Partially Read Body",
+			Body:          "helloworld",
+			ContentLength: 10,
+			Read:          3,
+			ExpSize:       10,
+			ExpConsumed:   3,
+			ExpOK:         true,
+		},
+		{
+			Name:          "Read Body With Unknown Length",
+			Body:          "hello",
+			ContentLength: -1,
+			Read:          5,
+			ExpSize:       5,
+			ExpConsumed:   5,
+			ExpOK:         true,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(testCase.Body))
+			r.ContentLength = testCase.ContentLength
+			if testCase.ExpOK && testCase.ContentLength >= 0 {
+				r.Header.Set("Content-Length", strconv.FormatInt(testCase.ContentLength, 10))
+			}
+
+			r, body := Wrap(r, nil)
+			if testCase.Read > 0 {
+				_, err := io.CopyN(io.Discard, r.Body, testCase.Read)
+				require.NoError(t, err)
+			}
+			size, ok := Request(r, body)
+			assert.Equal(t, testCase.ExpSize, size)
+			assert.Equal(t, testCase.ExpOK, ok)
+
+			consumed, ok := Consumed(r, body)
+			assert.Equal(t, testCase.ExpConsumed, consumed)
+			assert.Equal(t, testCase.ExpOK, ok)
+		})
+	}
+}
+
+func TestResponse(t *testing.T) {
+	size, ok := Response(http.Header{}, 0)
+	assert.Equal(t, int64(0), size)
+	assert.False(t, ok)
+
+	size, ok = Response(http.Header{"Content-Length": []string{"0"}}, 0)
+	assert.Equal(t, int64(0), size)
+	assert.True(t, ok)
+
+	size, ok = Response(http.Header{}, 13)
+	assert.Equal(t, int64(13), size)
+	assert.True(t, ok)
+}
@@ -0,0 +1,120 @@
+// Package chiroute resolves the chi route of a request, including telling
+// apart the requests which are not found from the ones whose method is not
+// allowed.
+package chiroute
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// UnmatchedKey is the attribute key set when the request doesn't match any
+// route, the value is either NotFound or MethodNotAllowed.
+const UnmatchedKey = attribute.Key("http.route.unmatched")
+
+// Values of UnmatchedKey.
+const (
+	NotFound         = "not_found"
+	MethodNotAllowed = "method_not_allowed"
+)
+
+// probedMethods are the methods checked for telling apart MethodNotAllowed
+// from NotFound. They are capped to the commonly routed methods since every
+// probe walks the routes once again, so the routes only registered for the
+// other methods are reported as NotFound.
+var probedMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// Resolve returns the route pattern of the request, along with NotFound or
+// MethodNotAllowed when it doesn't match any route. The routes are used for
+// matching the request before it is routed by chi, when they are nil the
+// routes in the chi route context are used. The result is remembered in the
+// request state, so the routes are matched at most once per request.
+func Resolve(r *http.Request, routes chi.Routes) (pattern string, unmatched string) {
+	state := requeststate.FromContext(r.Context())
+	rctx := chi.RouteContext(r.Context())
+	if pattern, unmatched, ok := state.Route(); ok {
+		// prefer the route chi has actually routed the request to, e.g
+		// when the path is rewritten after the route is resolved
+		if routed := routedPattern(rctx); unmatched != "" && routed != "" {
+			state.SetRoute(routed, "")
+			return routed, ""
+		}
+		return pattern, unmatched
+	}
+
+	if routes == nil {
+		if rctx == nil {
+			return "", ""
+		}
+		if rctx.Routes == nil {
+			return rctx.RoutePattern(), ""
+		}
+		if routed := routedPattern(rctx); routed != "" {
+			state.SetRoute(routed, "")
+			return routed, ""
+		}
+		routes = rctx.Routes
+	}
+	pattern, unmatched = Lookup(routes, r)
+	state.SetRoute(pattern, unmatched)
+	return pattern, unmatched
+}
+
+// Lookup matches the request against routes. When the request matches a
+// route, its pattern is returned along with empty unmatched. Otherwise the
+// pattern is empty & unmatched is either NotFound or MethodNotAllowed.
+func Lookup(routes chi.Routes, r *http.Request) (pattern string, unmatched string) {
+	path := routePath(r)
+	rctx := chi.NewRouteContext()
+	if routes.Match(rctx, r.Method, path) {
+		return rctx.RoutePattern(), ""
+	}
+	for _, method := range probedMethods {
+		if method != r.Method && routes.Match(chi.NewRouteContext(), method, path) {
+			return "", MethodNotAllowed
+		}
+	}
+	return "", NotFound
+}
+
+// routePath returns the path used by chi for routing the request, the raw
+// path is preferred so the escaped slashes (e.g `%2F`) stay in one segment.
+func routePath(r *http.Request) string {
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
+
+// routedPattern returns the pattern of the route the request is routed to.
+// It returns empty string when the request is not routed yet or the pattern
+// is a wildcard of a mounted router, since the request may not match any
+// route of the mounted router.
+func routedPattern(rctx *chi.Context) string {
+	if rctx == nil {
+		return ""
+	}
+	if pattern := rctx.RoutePattern(); pattern != "" && !strings.HasSuffix(pattern, "*") {
+		return pattern
+	}
+	return ""
+}
+
+// Attr returns the attribute for the unmatched value.
+func Attr(unmatched string) attribute.KeyValue {
+	return UnmatchedKey.String(unmatched)
+}
@@ -0,0 +1,212 @@
+package forwarded
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Info contains the request origin resolved by Resolver.
+type Info struct {
+	// ClientAddress is the address of the client that initiated the request.
+	ClientAddress string
+	// Scheme is the scheme used by the client, either `http` or `https`.
+	Scheme string
+	// Host is the host requested by the client, it could be empty.
+	Host string
+}
+
+// Resolver resolves the request origin using `Forwarded`, `X-Forwarded-For`,
+// `X-Forwarded-Proto` & `X-Forwarded-Host` headers. The headers are only
+// used when they are set by trusted proxies, so they can't be spoofed by the
+// client.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver returns Resolver which trusts the given proxy CIDRs.
+func NewResolver(trusted []netip.Prefix) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// Resolve returns the request origin. When the immediate peer is not a
+// trusted proxy, the forwarding headers are ignored.
+func (res *Resolver) Resolve(req *http.Request) Info {
+	info := Info{
+		ClientAddress: remoteIP(req.RemoteAddr),
+		Scheme:        "http",
+		Host:          req.Host,
+	}
+	if req.TLS != nil {
+		info.Scheme = "https"
+	}
+	if res == nil || !res.isTrusted(info.ClientAddress) {
+		return info
+	}
+
+	// the forwarding headers are set by trusted proxy, prefer the
+	// standardized `Forwarded` header over the `X-Forwarded-*` headers
+	if fwd := req.Header.Values("Forwarded"); len(fwd) > 0 {
+		res.resolveForwarded(&info, fwd)
+	} else {
+		res.resolveXForwarded(&info, req.Header)
+	}
+	return info
+}
+
+func (res *Resolver) resolveForwarded(info *Info, values []string) {
+	elements := parseForwarded(values)
+	if len(elements) == 0 {
+		// the header is empty, e.g `Forwarded: ,`
+		return
+	}
+
+	// walk the hops from the nearest proxy, the client is the first hop
+	// that is not trusted
+	idx := 0
+	for i := len(elements) - 1; i >= 0; i-- {
+		idx = i
+		if elements[i].forAddr == "" || !res.isTrusted(elements[i].forAddr) {
+			break
+		}
+	}
+	if addr := elements[idx].forAddr; addr != "" {
+		info.ClientAddress = addr
+	}
+
+	// proto & host are set by the proxy which received the request from
+	// the client
+	if proto := strings.ToLower(elements[idx].proto); proto == "http" || proto == "https" {
+		info.Scheme = proto
+	}
+	if elements[idx].host != "" {
+		info.Host = elements[idx].host
+	}
+}
+
+func (res *Resolver) resolveXForwarded(info *Info, h http.Header) {
+	protos := splitValues(h.Values("X-Forwarded-Proto"))
+	hosts := splitValues(h.Values("X-Forwarded-Host"))
+
+	// without `X-Forwarded-For` the values appended by the nearest proxy
+	// are used, the preceding ones could be set by the client
+	idx, hopCount := -1, 0
+	if hops := splitValues(h.Values("X-Forwarded-For")); len(hops) > 0 {
+		// walk the hops from the nearest proxy, the client is the first
+		// hop that is not trusted
+		idx, hopCount = 0, len(hops)
+		for i := len(hops) - 1; i >= 0; i-- {
+			idx = i
+			if !res.isTrusted(hops[i]) {
+				break
+			}
+		}
+		info.ClientAddress = hops[idx]
+	}
+
+	// proto & host are taken from the same hop as the client address when
+	// every hop appended them, otherwise the values set by the nearest
+	// proxy are used, e.g the proxies which only send a single proto
+	if proto := strings.ToLower(valueAt(protos, idx, hopCount)); proto == "http" || proto == "https" {
+		info.Scheme = proto
+	}
+	if host := valueAt(hosts, idx, hopCount); host != "" {
+		info.Host = host
+	}
+}
+
+// valueAt returns the value at the given index when the values are aligned
+// with the hops, i.e there are as many values as hops. Otherwise the last
+// value is returned. It returns empty string when there is no value.
+func valueAt(values []string, idx, hopCount int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if idx < 0 || len(values) != hopCount {
+		return values[len(values)-1]
+	}
+	return values[idx]
+}
+
+func (res *Resolver) isTrusted(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range res.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedElement is a single hop in the `Forwarded` header.
+type forwardedElement struct {
+	forAddr string
+	proto   string
+	host    string
+}
+
+// parseForwarded parses `Forwarded` header values as described in RFC 7239.
+func parseForwarded(values []string) []forwardedElement {
+	var elements []forwardedElement
+	for _, element := range splitValues(values) {
+		var fe forwardedElement
+		for _, pair := range strings.Split(element, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			val = strings.Trim(val, `"`)
+			switch strings.ToLower(key) {
+			case "for":
+				fe.forAddr = nodeIP(val)
+			case "proto":
+				fe.proto = val
+			case "host":
+				fe.host = val
+			}
+		}
+		elements = append(elements, fe)
+	}
+	return elements
+}
+
+// nodeIP returns the IP of the node identifier, e.g `192.0.2.43:47011` or
+// `[2001:db8:cafe::17]:4711`. Obfuscated identifiers are returned as is.
+func nodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// remoteIP returns the IP of http.Request.RemoteAddr.
+func remoteIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// splitValues splits comma separated header values.
+func splitValues(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				result = append(result, v)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,154 @@
+package forwarded_test
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/riandyrn/otelchi/internal/forwarded"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver(t *testing.T) {
+	resolver := forwarded.NewResolver([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	})
+
+	// prepare test cases
+	testCases := []struct {
+		Name       string
+		RemoteAddr string
+		TLS        bool
+		Headers    map[string]string
+		ExpInfo    forwarded.Info
+	}{
+		{
+			Name:       "No Forwarding Headers",
+			RemoteAddr: "10.0.0.1:1234",
+			ExpInfo:    forwarded.Info{ClientAddress: "10.0.0.1", Scheme: "http", Host: "example.com"},
+		},
+		{
+			Name:       "TLS Without Forwarding Headers",
+			RemoteAddr: "192.0.2.1:1234",
+			TLS:        true,
+			ExpInfo:    forwarded.Info{ClientAddress: "192.0.2.1", Scheme: "https", Host: "example.com"},
+		},
+		{
+			Name:       "Untrusted Peer",
+			RemoteAddr: "192.0.2.1:1234",
+			Headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "spoofed.com",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "192.0.2.1", Scheme: "http", Host: "example.com"},
+		},
+		{
+			Name:       "Trusted Peer With X-Forwarded Headers",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1, 10.0.0.2",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "api.example.com",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "198.51.100.1", Scheme: "https", Host: "api.example.com"},
+		},
+		{
+			Name:       "Spoofed X-Forwarded-For Behind Trusted Peer",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"X-Forwarded-For": "1.2.3.4, 198.51.100.1",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "198.51.100.1", Scheme: "http", Host: "example.com"},
+		},
+		{
+			Name:       "Spoofed X-Forwarded-Proto & X-Forwarded-Host Behind Trusted Peer",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"X-Forwarded-For":   "1.2.3.4, 198.51.100.1",
+				"X-Forwarded-Proto": "https, http",
+				"X-Forwarded-Host":  "evil.com, real.com",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "198.51.100.1", Scheme: "http", Host: "real.com"},
+		},
+		{
+			// e.g AWS ALB appends to `X-Forwarded-For` but sets a single
+			// `X-Forwarded-Proto` value
+			Name:       "Spoofed X-Forwarded-For With Single X-Forwarded-Proto",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"X-Forwarded-For":   "6.6.6.6, 1.2.3.4",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "api.example.com",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "1.2.3.4", Scheme: "https", Host: "api.example.com"},
+		},
+		{
+			Name:       "X-Forwarded-Proto & X-Forwarded-Host Without X-Forwarded-For",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"X-Forwarded-Proto": "http, https",
+				"X-Forwarded-Host":  "evil.com, real.com",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "10.0.0.1", Scheme: "https", Host: "real.com"},
+		},
+		{
+			Name:       "All Hops Trusted",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"X-Forwarded-For": "10.0.0.3, 10.0.0.2",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "10.0.0.3", Scheme: "http", Host: "example.com"},
+		},
+		{
+			Name:       "All Hops Trusted With Forwarded Header",
+			RemoteAddr: "[2001:db8::1]:1234",
+			Headers: map[string]string{
+				"Forwarded":       `for="[2001:db8:cafe::17]:4711";proto=https;host=api.example.com, for=10.0.0.2`,
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "2001:db8:cafe::17", Scheme: "https", Host: "api.example.com"},
+		},
+		{
+			Name:       "Forwarded Header From Untrusted Client",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"Forwarded": `for=192.0.2.43:47011;proto=https;host=api.example.com, for=10.0.0.2;proto=http`,
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "192.0.2.43", Scheme: "https", Host: "api.example.com"},
+		},
+		{
+			Name:       "Empty Forwarded Header",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"Forwarded": ",",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "10.0.0.1", Scheme: "http", Host: "example.com"},
+		},
+		{
+			Name:       "Blank Forwarded Header",
+			RemoteAddr: "10.0.0.1:1234",
+			Headers: map[string]string{
+				"Forwarded": "",
+			},
+			ExpInfo: forwarded.Info{ClientAddress: "10.0.0.1", Scheme: "http", Host: "example.com"},
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.RemoteAddr = testCase.RemoteAddr
+			if testCase.TLS {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for key, val := range testCase.Headers {
+				req.Header.Set(key, val)
+			}
+			assert.Equal(t, testCase.ExpInfo, resolver.Resolve(req))
+		})
+	}
+}
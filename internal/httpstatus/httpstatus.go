@@ -0,0 +1,11 @@
+// Package httpstatus contains helpers for HTTP status codes shared by the
+// recording response writers of the middleware & the metric package.
+package httpstatus
+
+import "net/http"
+
+// IsInformational reports whether the status code is a 1xx response other
+// than `101 Switching Protocols`, which is a final response.
+func IsInformational(statusCode int) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols
+}
@@ -0,0 +1,120 @@
+// Package requeststate provides the state of a request shared by the tracing
+// middleware, the metric middlewares & the handler.
+package requeststate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// State holds the request scoped state, it is safe for concurrent use.
+type State struct {
+	mu  sync.Mutex
+	err error
+
+	// the route resolved for the request, it is shared so the routes are
+	// only matched once per request
+	route         string
+	unmatched     string
+	routeResolved bool
+}
+
+type contextKey struct{}
+
+// FromContext returns the State in the context, it returns nil when there
+// is none.
+func FromContext(ctx context.Context) *State {
+	s, _ := ctx.Value(contextKey{}).(*State)
+	return s
+}
+
+// Ensure returns the context with State, the existing State is reused so
+// the stacked middlewares share the same one.
+func Ensure(ctx context.Context) (context.Context, *State) {
+	if s := FromContext(ctx); s != nil {
+		return ctx, s
+	}
+	s := &State{}
+	return context.WithValue(ctx, contextKey{}, s), s
+}
+
+// SetError remembers the error returned by the handler, the last one wins.
+func (s *State) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Error returns the error remembered by SetError.
+func (s *State) Error() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// SetRoute remembers the route pattern resolved for the request, unmatched
+// is set when the request doesn't match any route. It does nothing on nil
+// State.
+func (s *State) SetRoute(pattern, unmatched string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.route = pattern
+	s.unmatched = unmatched
+	s.routeResolved = true
+}
+
+// Route returns the route remembered by SetRoute, ok is false when the route
+// is not resolved yet.
+func (s *State) Route() (pattern, unmatched string, ok bool) {
+	if s == nil {
+		return "", "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.route, s.unmatched, s.routeResolved
+}
+
+// ErrorType returns the `error.type` attribute for err, the value is the Go
+// type of the error.
+func ErrorType(err error) attribute.KeyValue {
+	return semconv.ErrorTypeKey.String(fmt.Sprintf("%T", err))
+}
+
+// AbortedKey is the attribute key set when the request is aborted before the
+// handler returns, the value is either ClientCancelled or DeadlineExceeded.
+const AbortedKey = attribute.Key("http.request.aborted")
+
+// Values of AbortedKey.
+const (
+	ClientCancelled  = "client_cancelled"
+	DeadlineExceeded = "deadline_exceeded"
+)
+
+// AbortReason returns the reason why the request context is done, it returns
+// empty string when the context is not done yet. The cancellation is only
+// attributed to the client when it has no cause, as done by the server when
+// the client goes away. The cancellation with a cause (e.g the base context
+// cancelled by `context.WithCancelCause` while shutting down the server) is
+// not reported.
+func AbortReason(ctx context.Context) string {
+	switch ctx.Err() {
+	case nil:
+		return ""
+	case context.DeadlineExceeded:
+		return DeadlineExceeded
+	}
+	if context.Cause(ctx) != context.Canceled {
+		return ""
+	}
+	return ClientCancelled
+}
@@ -0,0 +1,70 @@
+package requeststate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestEnsure(t *testing.T) {
+	// the state is created when there is none
+	assert.Nil(t, FromContext(context.Background()))
+	ctx, state := Ensure(context.Background())
+	assert.Same(t, state, FromContext(ctx))
+
+	// the existing state is reused
+	nextCtx, nextState := Ensure(ctx)
+	assert.Equal(t, ctx, nextCtx)
+	assert.Same(t, state, nextState)
+}
+
+func TestError(t *testing.T) {
+	// nil state has no error
+	var state *State
+	assert.NoError(t, state.Error())
+
+	errFoo := errors.New("foo")
+	state = &State{}
+	state.SetError(errFoo)
+	assert.Equal(t, errFoo, state.Error())
+	assert.Equal(t, attribute.String("error.type", "*errors.errorString"), ErrorType(errFoo))
+}
+
+func TestRoute(t *testing.T) {
+	// nil state never resolves the route
+	var state *State
+	state.SetRoute("/foo", "")
+	_, _, ok := state.Route()
+	assert.False(t, ok)
+
+	state = &State{}
+	_, _, ok = state.Route()
+	assert.False(t, ok)
+
+	state.SetRoute("", "not_found")
+	pattern, unmatched, ok := state.Route()
+	assert.True(t, ok)
+	assert.Equal(t, "", pattern)
+	assert.Equal(t, "not_found", unmatched)
+}
+
+func TestAbortReason(t *testing.T) {
+	assert.Equal(t, "", AbortReason(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, ClientCancelled, AbortReason(ctx))
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	assert.Equal(t, DeadlineExceeded, AbortReason(ctx))
+
+	// the cancellation with a cause is not caused by the client
+	ctx, cancelCause := context.WithCancelCause(context.Background())
+	cancelCause(errors.New("server is shutting down"))
+	assert.Equal(t, "", AbortReason(ctx))
+}
@@ -0,0 +1,342 @@
+package semconvutil
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/riandyrn/otelchi/internal/forwarded"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconvold "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/semconv/v1.20.0/httpconv"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// HTTPServer generates HTTP server attributes according to the configured
+// semantic conventions mode.
+type HTTPServer struct {
+	mode     Mode
+	resolver *forwarded.Resolver
+}
+
+// NewHTTPServer returns HTTPServer for the given mode, ModeDefault is
+// resolved from the environment once during construction. When resolver is
+// set, the client address, scheme & host are resolved using it.
+func NewHTTPServer(mode Mode, resolver *forwarded.Resolver) HTTPServer {
+	return HTTPServer{mode: mode.Resolve(), resolver: resolver}
+}
+
+// EmitOld returns true when the old attributes should be emitted.
+func (s HTTPServer) EmitOld() bool {
+	return s.mode != ModeStable
+}
+
+// EmitStable returns true when the stable attributes should be emitted.
+func (s HTTPServer) EmitStable() bool {
+	return s.mode == ModeStable || s.mode == ModeDup
+}
+
+// SchemaURL returns the schema URL of the emitted semantic conventions. When
+// both versions are emitted the old schema is used.
+func (s HTTPServer) SchemaURL() string {
+	if s.EmitOld() {
+		return semconvold.SchemaURL
+	}
+	return semconv.SchemaURL
+}
+
+// RequestTraceAttrs returns the attributes known at the start of the server
+// span. The server parameter is the name of the (virtual) server handling the
+// request, when it is empty the request host is used instead.
+func (s HTTPServer) RequestTraceAttrs(server string, req *http.Request) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if s.EmitOld() {
+		attrs = httpconv.ServerRequest(server, req)
+	}
+	if s.EmitStable() {
+		attrs = append(attrs, stableRequestTraceAttrs(server, req)...)
+	}
+	if s.resolver != nil {
+		attrs = s.overrideOrigin(attrs, server, req)
+	}
+	return attrs
+}
+
+// overrideOrigin replaces the client address, scheme & host attributes with
+// the ones resolved from the trusted forwarding headers. The server name
+// still takes precedence over the forwarded host.
+func (s HTTPServer) overrideOrigin(attrs []attribute.KeyValue, server string, req *http.Request) []attribute.KeyValue {
+	info := s.resolver.Resolve(req)
+	host, _ := splitHostPort(info.Host)
+	if s.EmitOld() {
+		attrs = setAttr(attrs, semconvold.HTTPClientIP(info.ClientAddress))
+		attrs = setAttr(attrs, semconvold.HTTPSchemeKey.String(info.Scheme))
+		if server == "" && host != "" {
+			attrs = setAttr(attrs, semconvold.NetHostName(host))
+		}
+	}
+	if s.EmitStable() {
+		attrs = setAttr(attrs, semconv.ClientAddress(info.ClientAddress))
+		attrs = setAttr(attrs, semconv.URLScheme(info.Scheme))
+		if server == "" && host != "" {
+			attrs = setAttr(attrs, semconv.ServerAddress(host))
+		}
+	}
+	return attrs
+}
+
+// setAttr replaces the attribute with the same key, or appends it when the
+// key doesn't exist yet.
+func setAttr(attrs []attribute.KeyValue, kv attribute.KeyValue) []attribute.KeyValue {
+	for i := range attrs {
+		if attrs[i].Key == kv.Key {
+			attrs[i] = kv
+			return attrs
+		}
+	}
+	return append(attrs, kv)
+}
+
+// Route returns the `http.route` attribute, it is identical in both versions.
+func (s HTTPServer) Route(route string) attribute.KeyValue {
+	return semconv.HTTPRoute(route)
+}
+
+// StatusCode returns the response status code attributes.
+func (s HTTPServer) StatusCode(code int) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, 2)
+	if s.EmitOld() {
+		attrs = append(attrs, semconvold.HTTPStatusCode(code))
+	}
+	if s.EmitStable() {
+		attrs = append(attrs, semconv.HTTPResponseStatusCode(code))
+	}
+	return attrs
+}
+
+// RequestBodySize returns the request body size attributes.
+func (s HTTPServer) RequestBodySize(size int64) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, 2)
+	if s.EmitOld() {
+		attrs = append(attrs, semconvold.HTTPRequestContentLength(int(size)))
+	}
+	if s.EmitStable() {
+		attrs = append(attrs, semconv.HTTPRequestBodySize(int(size)))
+	}
+	return attrs
+}
+
+// ResponseBodySize returns the response body size attributes.
+func (s HTTPServer) ResponseBodySize(size int64) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, 2)
+	if s.EmitOld() {
+		attrs = append(attrs, semconvold.HTTPResponseContentLength(int(size)))
+	}
+	if s.EmitStable() {
+		attrs = append(attrs, semconv.HTTPResponseBodySize(int(size)))
+	}
+	return attrs
+}
+
+// ServerStatus returns the span status for the response status code as
+// specified by the semantic conventions. Status codes in the 400-499 range
+// are not returned as errors.
+func ServerStatus(code int) (codes.Code, string) {
+	return httpconv.ServerStatus(code)
+}
+
+// MetricAttrs returns the default attributes of the metric records. The
+// route attribute is omitted when route is empty.
+func (s HTTPServer) MetricAttrs(req *http.Request, route string) []attribute.KeyValue {
+	scheme := scheme(req)
+	if s.resolver != nil {
+		scheme = s.resolver.Resolve(req).Scheme
+	}
+
+	attrs := make([]attribute.KeyValue, 0, 5)
+	if s.EmitOld() {
+		// unknown methods are normalized to prevent unbounded cardinality
+		oldMethod := req.Method
+		if oldMethod != "" && !IsKnownMethod(oldMethod) {
+			oldMethod = OtherValue
+		}
+		attrs = append(attrs, semconvold.HTTPMethod(oldMethod), semconvold.HTTPSchemeKey.String(scheme))
+	}
+	if s.EmitStable() {
+		attrs = append(attrs, method(req.Method), semconv.URLScheme(scheme))
+	}
+	if route != "" {
+		attrs = append(attrs, s.Route(route))
+	}
+	return attrs
+}
+
+func stableRequestTraceAttrs(server string, req *http.Request) []attribute.KeyValue {
+	var host string
+	var port int
+	if server == "" {
+		host, port = splitHostPort(req.Host)
+	} else {
+		// prioritize the primary server name
+		host, port = splitHostPort(server)
+		if port < 0 {
+			_, port = splitHostPort(req.Host)
+		}
+	}
+
+	attrs := make([]attribute.KeyValue, 0, 12)
+	attrs = append(attrs, method(req.Method))
+	if req.Method != "" && !IsKnownMethod(req.Method) {
+		attrs = append(attrs, semconv.HTTPRequestMethodOriginal(req.Method))
+	}
+	attrs = append(attrs, semconv.URLScheme(scheme(req)))
+	if host != "" {
+		attrs = append(attrs, semconv.ServerAddress(host))
+	}
+	if port = requiredHTTPPort(req.TLS != nil, port); port > 0 {
+		attrs = append(attrs, semconv.ServerPort(port))
+	}
+	if req.URL != nil && req.URL.Path != "" {
+		attrs = append(attrs, semconv.URLPath(req.URL.Path))
+	}
+
+	peer, peerPort := splitHostPort(req.RemoteAddr)
+	if peer != "" {
+		attrs = append(attrs, semconv.NetworkPeerAddress(peer))
+		if peerPort > 0 {
+			attrs = append(attrs, semconv.NetworkPeerPort(peerPort))
+		}
+	}
+
+	// by default client address is taken from the first X-Forwarded-For
+	// entry, the same way `http.client_ip` is derived in the old version
+	clientIP := firstForwardedFor(req.Header.Get("X-Forwarded-For"))
+	if clientIP == "" {
+		clientIP = peer
+	}
+	if clientIP != "" {
+		attrs = append(attrs, semconv.ClientAddress(clientIP))
+	}
+
+	if ua := req.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.UserAgentOriginal(ua))
+	}
+
+	if name, version := netProtocol(req.Proto); name != "" {
+		if name != "http" {
+			attrs = append(attrs, semconv.NetworkProtocolName(name))
+		}
+		if version != "" {
+			attrs = append(attrs, semconv.NetworkProtocolVersion(version))
+		}
+	}
+
+	return attrs
+}
+
+var knownMethods = map[string]attribute.KeyValue{
+	http.MethodConnect: semconv.HTTPRequestMethodConnect,
+	http.MethodDelete:  semconv.HTTPRequestMethodDelete,
+	http.MethodGet:     semconv.HTTPRequestMethodGet,
+	http.MethodHead:    semconv.HTTPRequestMethodHead,
+	http.MethodOptions: semconv.HTTPRequestMethodOptions,
+	http.MethodPatch:   semconv.HTTPRequestMethodPatch,
+	http.MethodPost:    semconv.HTTPRequestMethodPost,
+	http.MethodPut:     semconv.HTTPRequestMethodPut,
+	http.MethodTrace:   semconv.HTTPRequestMethodTrace,
+}
+
+// OtherValue is the value used in place of unknown HTTP methods.
+const OtherValue = "_OTHER"
+
+// IsKnownMethod returns true when m is one of the HTTP methods known by the
+// semantic conventions.
+func IsKnownMethod(m string) bool {
+	_, ok := knownMethods[m]
+	return ok
+}
+
+// method returns the `http.request.method` attribute, unknown methods are
+// reported as `_OTHER` as required by the specification.
+func method(m string) attribute.KeyValue {
+	if m == "" {
+		return semconv.HTTPRequestMethodGet
+	}
+	if kv, ok := knownMethods[m]; ok {
+		return kv
+	}
+	return semconv.HTTPRequestMethodOther
+}
+
+func scheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func netProtocol(proto string) (name, version string) {
+	name, version, _ = strings.Cut(proto, "/")
+	return strings.ToLower(name), version
+}
+
+func firstForwardedFor(xForwardedFor string) string {
+	if idx := strings.Index(xForwardedFor, ","); idx >= 0 {
+		xForwardedFor = xForwardedFor[:idx]
+	}
+	return strings.TrimSpace(xForwardedFor)
+}
+
+// splitHostPort splits a network address hostport of the form "host",
+// "host%zone", "[host]", "[host%zone], "host:port", "host%zone:port",
+// "[host]:port", "[host%zone]:port", or ":port" into host or host%zone and
+// port. An empty host is returned if it is not provided or unparsable. A
+// negative port is returned if it is not provided or unparsable.
+func splitHostPort(hostport string) (host string, port int) {
+	port = -1
+
+	if strings.HasPrefix(hostport, "[") {
+		addrEnd := strings.LastIndex(hostport, "]")
+		if addrEnd < 0 {
+			// invalid hostport
+			return
+		}
+		if i := strings.LastIndex(hostport[addrEnd:], ":"); i < 0 {
+			host = hostport[1:addrEnd]
+			return
+		}
+	} else {
+		if i := strings.LastIndex(hostport, ":"); i < 0 {
+			host = hostport
+			return
+		}
+	}
+
+	host, pStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return
+	}
+
+	p, err := strconv.ParseUint(pStr, 10, 16)
+	if err != nil {
+		return
+	}
+	return host, int(p)
+}
+
+// requiredHTTPPort returns the port only when it differs from the default
+// port of the scheme, otherwise -1 is returned.
+func requiredHTTPPort(https bool, port int) int {
+	if https {
+		if port > 0 && port != 443 {
+			return port
+		}
+	} else {
+		if port > 0 && port != 80 {
+			return port
+		}
+	}
+	return -1
+}
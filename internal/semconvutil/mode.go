@@ -0,0 +1,54 @@
+package semconvutil
+
+import (
+	"os"
+	"strings"
+)
+
+// envStabilityOptIn is the environment variable used by OpenTelemetry
+// instrumentations to opt in into the stable semantic conventions.
+//
+// See https://opentelemetry.io/docs/specs/semconv/http/ for details.
+const envStabilityOptIn = "OTEL_SEMCONV_STABILITY_OPT_IN"
+
+// Mode determines which version of HTTP semantic conventions is emitted.
+type Mode int
+
+const (
+	// ModeDefault resolves the mode from `OTEL_SEMCONV_STABILITY_OPT_IN`,
+	// falling back to ModeOld when the variable is unset.
+	ModeDefault Mode = iota
+	// ModeOld emits attributes from semconv v1.20.0 (e.g `http.method`).
+	ModeOld
+	// ModeStable emits attributes from stable semconv v1.26.0
+	// (e.g `http.request.method`).
+	ModeStable
+	// ModeDup emits both the old and the stable attributes, this is useful
+	// during migration.
+	ModeDup
+)
+
+// ModeFromEnv returns the mode defined in `OTEL_SEMCONV_STABILITY_OPT_IN`.
+// The variable holds a comma separated list of values, `http/dup` takes
+// precedence over `http`.
+func ModeFromEnv() Mode {
+	mode := ModeOld
+	for _, val := range strings.Split(os.Getenv(envStabilityOptIn), ",") {
+		switch strings.TrimSpace(val) {
+		case "http/dup":
+			return ModeDup
+		case "http":
+			mode = ModeStable
+		}
+	}
+	return mode
+}
+
+// Resolve returns the effective mode, ModeDefault is resolved from the
+// environment.
+func (m Mode) Resolve() Mode {
+	if m == ModeDefault {
+		return ModeFromEnv()
+	}
+	return m
+}
@@ -0,0 +1,139 @@
+package metric
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/riandyrn/otelchi/internal/semconvutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+const (
+	metricNameAttributeValuesFolded = "metric_attribute_values_folded"
+	metricUnitAttributeValuesFolded = "{value}"
+	metricDescAttributeValuesFolded = "Measures the number of attribute values replaced by `_OTHER` due to the cardinality limit."
+
+	// OtherValue is the value used in place of the attribute values exceeding the cardinality limit &
+	// the unknown HTTP methods.
+	OtherValue = semconvutil.OtherValue
+
+	// FoldedAttributeKey is the attribute of `metric_attribute_values_folded` counter containing the
+	// key of the folded attribute.
+	FoldedAttributeKey = attribute.Key("attribute.key")
+)
+
+// methodKeys are the attribute keys containing the HTTP method.
+var methodKeys = map[attribute.Key]bool{
+	"http.method":         true,
+	"http.request.method": true,
+}
+
+// WithCardinalityLimit caps the number of distinct values of every attribute key in the metric records.
+// Once the limit of a key is reached, the new values are replaced by `_OTHER` & counted by the
+// `metric_attribute_values_folded` counter. Unknown HTTP methods are normalized to `_OTHER` as well.
+// If none is specified, the values are not capped.
+func WithCardinalityLimit(limit int) Option {
+	return optionFunc(func(cfg *BaseConfig) {
+		cfg.cardinalityLimit = limit
+	})
+}
+
+// WithAttributeCardinalityLimit caps the number of distinct values of the given attribute key, it takes
+// precedence over [WithCardinalityLimit]. Use 0 for not capping the values of the key.
+func WithAttributeCardinalityLimit(key attribute.Key, limit int) Option {
+	return optionFunc(func(cfg *BaseConfig) {
+		if cfg.attributeCardinalityLimits == nil {
+			cfg.attributeCardinalityLimits = map[attribute.Key]int{}
+		}
+		cfg.attributeCardinalityLimits[key] = limit
+	})
+}
+
+// cardinalityGuard replaces the attribute values exceeding the cardinality limit with `_OTHER`. It is
+// shared by every copy of [BaseConfig] so the distinct values are tracked across the middlewares.
+type cardinalityGuard struct {
+	defaultLimit int
+	limits       map[attribute.Key]int
+	folded       otelmetric.Int64Counter
+
+	mu     sync.Mutex
+	values map[attribute.Key]map[attribute.Value]struct{}
+}
+
+func newCardinalityGuard(meter otelmetric.Meter, defaultLimit int, limits map[attribute.Key]int) *cardinalityGuard {
+	folded, err := newAttributeValuesFolded(meter)
+	if err != nil {
+		otel.Handle(err)
+		folded = noop.Int64Counter{}
+	}
+	return &cardinalityGuard{
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		folded:       folded,
+		values:       map[attribute.Key]map[attribute.Value]struct{}{},
+	}
+}
+
+func newAttributeValuesFolded(meter otelmetric.Meter) (otelmetric.Int64Counter, error) {
+	counter, err := meter.Int64Counter(
+		metricNameAttributeValuesFolded,
+		otelmetric.WithDescription(metricDescAttributeValuesFolded),
+		otelmetric.WithUnit(metricUnitAttributeValuesFolded),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s counter: %w", metricNameAttributeValuesFolded, err)
+	}
+	return counter, nil
+}
+
+// limit returns the cardinality limit of the key, 0 means the values are not capped.
+func (g *cardinalityGuard) limit(key attribute.Key) int {
+	if limit, ok := g.limits[key]; ok {
+		return limit
+	}
+	return g.defaultLimit
+}
+
+// apply returns the attributes with the values exceeding the cardinality limit replaced by `_OTHER`,
+// the given slice is never modified.
+func (g *cardinalityGuard) apply(ctx context.Context, attrs []attribute.KeyValue) []attribute.KeyValue {
+	guarded := make([]attribute.KeyValue, len(attrs))
+	copy(guarded, attrs)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, kv := range guarded {
+		// unknown methods are normalized as specified by the semantic conventions
+		if methodKeys[kv.Key] && kv.Value.Type() == attribute.STRING && !semconvutil.IsKnownMethod(kv.Value.AsString()) {
+			guarded[i] = kv.Key.String(OtherValue)
+			continue
+		}
+
+		limit := g.limit(kv.Key)
+		if limit <= 0 || (kv.Value.Type() == attribute.STRING && kv.Value.AsString() == OtherValue) {
+			continue
+		}
+		values, ok := g.values[kv.Key]
+		if !ok {
+			values = map[attribute.Value]struct{}{}
+			g.values[kv.Key] = values
+		}
+		if _, ok := values[kv.Value]; ok {
+			continue
+		}
+		if len(values) < limit {
+			values[kv.Value] = struct{}{}
+			continue
+		}
+
+		// the limit is reached, fold the value
+		guarded[i] = kv.Key.String(OtherValue)
+		g.folded.Add(ctx, 1, otelmetric.WithAttributes(FoldedAttributeKey.String(string(kv.Key))))
+	}
+	return guarded
+}
@@ -0,0 +1,137 @@
+package metric_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestCardinalityLimit(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name         string
+		Options      []metric.Option
+		Requests     []*http.Request
+		ExpCounts    map[string]uint64
+		ExpFolded    map[string]int64
+		ExpMethodKey attribute.Key
+	}{
+		{
+			Name:    "Route Exceeding Limit",
+			Options: []metric.Option{metric.WithCardinalityLimit(2)},
+			Requests: []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/a", nil),
+				httptest.NewRequest(http.MethodGet, "/b", nil),
+				httptest.NewRequest(http.MethodGet, "/c", nil),
+				httptest.NewRequest(http.MethodGet, "/d", nil),
+				httptest.NewRequest(http.MethodGet, "/a", nil),
+			},
+			ExpCounts: map[string]uint64{"GET /a": 2, "GET /b": 1, "GET _OTHER": 2},
+			ExpFolded: map[string]int64{"path": 2},
+		},
+		{
+			Name: "Per Key Limit",
+			Options: []metric.Option{
+				metric.WithCardinalityLimit(1),
+				metric.WithAttributeCardinalityLimit("path", 0),
+			},
+			Requests: []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/a", nil),
+				httptest.NewRequest(http.MethodGet, "/b", nil),
+				httptest.NewRequest(http.MethodPost, "/a", nil),
+			},
+			ExpCounts: map[string]uint64{"GET /a": 1, "GET /b": 1, "_OTHER /a": 1},
+			ExpFolded: map[string]int64{"method": 1},
+		},
+		{
+			Name: "Unknown Method",
+			Options: []metric.Option{
+				metric.WithAttributeCardinalityLimit("path", 10),
+			},
+			Requests: []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/a", nil),
+				httptest.NewRequest("FOO", "/a", nil),
+				httptest.NewRequest("BAR", "/a", nil),
+			},
+			ExpCounts:    map[string]uint64{"GET /a": 1, "_OTHER /a": 2},
+			ExpFolded:    map[string]int64{},
+			ExpMethodKey: "http.request.method",
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			methodKey := attribute.Key("method")
+			if testCase.ExpMethodKey != "" {
+				methodKey = testCase.ExpMethodKey
+			}
+
+			// the attributes func uses the raw path which has unbounded cardinality
+			opts := append([]metric.Option{
+				metric.WithMeterProvider(provider),
+				metric.WithAttributesFunc(func(req *http.Request) []attribute.KeyValue {
+					return []attribute.KeyValue{
+						methodKey.String(req.Method),
+						attribute.String("path", req.URL.Path),
+					}
+				}),
+				metric.WithResponseAttributesFunc(func(req *http.Request, info metric.ResponseInfo) []attribute.KeyValue {
+					return nil
+				}),
+			}, testCase.Options...)
+			baseCfg := metric.NewBaseConfig("test-server", opts...)
+
+			router := chi.NewRouter()
+			router.Use(metric.NewRequestDurationMillis(baseCfg))
+			router.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {})
+			for _, req := range testCase.Requests {
+				router.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			// read the recorded metrics
+			var rm metricdata.ResourceMetrics
+			err := reader.Collect(context.Background(), &rm)
+			require.NoError(t, err)
+			require.Len(t, rm.ScopeMetrics, 1)
+
+			metrics := map[string]metricdata.Metrics{}
+			for _, m := range rm.ScopeMetrics[0].Metrics {
+				metrics[m.Name] = m
+			}
+
+			hist, ok := metrics["request_duration_millis"].Data.(metricdata.Histogram[int64])
+			require.True(t, ok)
+			counts := map[string]uint64{}
+			for _, dp := range hist.DataPoints {
+				method, _ := dp.Attributes.Value(methodKey)
+				path, _ := dp.Attributes.Value("path")
+				counts[method.AsString()+" "+path.AsString()] = dp.Count
+			}
+			assert.Equal(t, testCase.ExpCounts, counts)
+
+			folded := map[string]int64{}
+			if m, ok := metrics["metric_attribute_values_folded"]; ok {
+				sum, ok := m.Data.(metricdata.Sum[int64])
+				require.True(t, ok)
+				for _, dp := range sum.DataPoints {
+					key, _ := dp.Attributes.Value(metric.FoldedAttributeKey)
+					folded[key.AsString()] = dp.Value
+				}
+			}
+			assert.Equal(t, testCase.ExpFolded, folded)
+		})
+	}
+}
@@ -1,11 +1,20 @@
 package metric
 
 import (
+	"bufio"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"sync"
 
 	"github.com/felixge/httpsnoop"
 	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/internal/chiroute"
+	"github.com/riandyrn/otelchi/internal/forwarded"
+	"github.com/riandyrn/otelchi/internal/httpstatus"
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	"github.com/riandyrn/otelchi/internal/semconvutil"
 	"github.com/riandyrn/otelchi/version"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -17,15 +26,43 @@ const (
 	ScopeName = "github.com/riandyrn/otelchi/metric"
 )
 
+// UnmatchedRouteKey is the attribute key set by the default attributes when the request doesn't match
+// any chi route, the value is either `not_found` or `method_not_allowed`.
+const UnmatchedRouteKey = chiroute.UnmatchedKey
+
 // BaseConfig is used to configure the metrics middleware.
 type BaseConfig struct {
 	// for initialization
-	meterProvider otelmetric.MeterProvider
+	meterProvider      otelmetric.MeterProvider
+	semconvMode        SemConvStabilityMode
+	trustedProxies     []netip.Prefix
+	durationHistograms []DurationHistogram
+	chiRoutes          chi.Routes
+
+	// cardinality protection
+	cardinalityLimit           int
+	attributeCardinalityLimits map[attribute.Key]int
+	guard                      *cardinalityGuard
 
 	// actual config state
-	Meter          otelmetric.Meter
-	ServerName     string
-	AttributesFunc func(req *http.Request) []attribute.KeyValue
+	Meter                  otelmetric.Meter
+	ServerName             string
+	AttributesFunc         func(req *http.Request) []attribute.KeyValue
+	ResponseAttributesFunc func(req *http.Request, info ResponseInfo) []attribute.KeyValue
+}
+
+// [ResponseInfo] contains the information of the response captured by the metrics middleware.
+type ResponseInfo struct {
+	// StatusCode is the response status code. When the handler panicked before writing the
+	// response header, it is set to 500 since this is what recoverer middleware usually sends.
+	StatusCode int
+	// WrittenBytes is the number of bytes written into the response body.
+	WrittenBytes int64
+	// Panicked is true when the handler panicked.
+	Panicked bool
+	// Hijacked is true when the handler hijacked the connection, bytes written
+	// into the hijacked connection are not counted in WrittenBytes.
+	Hijacked bool
 }
 
 // Option specifies instrumentation configuration options.
@@ -48,37 +85,124 @@ func WithMeterProvider(provider otelmetric.MeterProvider) Option {
 }
 
 // WithAttributesFunc specifies a function called to set attributes on a metric record for a given request.
-// If none is specified, otel `http.method`, `http.scheme` and `http.route` is used.
+// If none is specified, otel `http.method`, `http.scheme` and `http.route` is used. When the stable
+// semantic conventions are enabled, `http.request.method` and `url.scheme` are used instead. When the
+// request doesn't match any chi route, `http.route` is replaced by [UnmatchedRouteKey].
 func WithAttributesFunc(fn func(req *http.Request) []attribute.KeyValue) Option {
 	return optionFunc(func(cfg *BaseConfig) {
 		cfg.AttributesFunc = fn
 	})
 }
 
+// WithResponseAttributesFunc specifies a function called to set attributes on the metric records
+// which are recorded after the response is written, e.g the request duration & the response size.
+// The returned attributes are added to the ones returned by the function set in [WithAttributesFunc].
+// If none is specified, otel `http.status_code` is used. When the stable semantic conventions are
+// enabled, `http.response.status_code` is used instead.
+func WithResponseAttributesFunc(fn func(req *http.Request, info ResponseInfo) []attribute.KeyValue) Option {
+	return optionFunc(func(cfg *BaseConfig) {
+		cfg.ResponseAttributesFunc = fn
+	})
+}
+
+// SemConvStabilityMode determines which version of the HTTP semantic
+// conventions is emitted by the metric recorders.
+type SemConvStabilityMode = semconvutil.Mode
+
+const (
+	// SemConvStabilityModeDefault reads the mode from the
+	// `OTEL_SEMCONV_STABILITY_OPT_IN` environment variable. When it is set to
+	// `http` only the stable attributes are emitted, when it is set to
+	// `http/dup` both versions are emitted, otherwise the old attributes are
+	// emitted.
+	SemConvStabilityModeDefault = semconvutil.ModeDefault
+	// SemConvStabilityModeOld emits attributes from semconv v1.20.0.
+	SemConvStabilityModeOld = semconvutil.ModeOld
+	// SemConvStabilityModeStable emits attributes from the stable semconv v1.26.0.
+	SemConvStabilityModeStable = semconvutil.ModeStable
+	// SemConvStabilityModeDup emits both the old and the stable attributes.
+	SemConvStabilityModeDup = semconvutil.ModeDup
+)
+
+// WithSemConvStabilityMode specifies which version of the HTTP semantic conventions
+// is used by the default attributes. If none is specified, the mode is read from the
+// `OTEL_SEMCONV_STABILITY_OPT_IN` environment variable.
+func WithSemConvStabilityMode(mode SemConvStabilityMode) Option {
+	return optionFunc(func(cfg *BaseConfig) {
+		cfg.semconvMode = mode
+	})
+}
+
+// WithTrustedProxies specifies CIDRs of the proxies (e.g load balancers) in front of the server.
+// When the request comes from a trusted proxy, the scheme in the default attributes is derived from
+// the `Forwarded` or `X-Forwarded-Proto` header. Use the same CIDRs as `otelchi.WithTrustedProxies`
+// so spans & metrics agree with each other.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return optionFunc(func(cfg *BaseConfig) {
+		cfg.trustedProxies = append(cfg.trustedProxies, prefixes...)
+	})
+}
+
+// WithChiRoutes specifies the routes used by the application, it should be the root router. When it is set,
+// the route pattern in the default attributes is resolved from the request path up front, so the attributes
+// evaluated before executing the handler (e.g by [NewRequestInFlight]) contain `http.route` as well. When it
+// is not set, the routes in the chi route context are used, so it is only required when the middlewares wrap
+// the router instead of being registered via `Use`. The resolved route is shared by the stacked middlewares
+// of the request, so the routes are matched once per request.
+func WithChiRoutes(routes chi.Routes) Option {
+	return optionFunc(func(cfg *BaseConfig) {
+		cfg.chiRoutes = routes
+	})
+}
+
+// DurationHistogram is a histogram used by [Recorder] for recording the request duration.
+type DurationHistogram int
+
+const (
+	// DurationHistogramMillis is the legacy `request_duration_millis` histogram, see [NewRequestDurationMillis].
+	DurationHistogramMillis DurationHistogram = iota
+	// DurationHistogramSeconds is the `http.server.request.duration` histogram, see [NewRequestDuration].
+	DurationHistogramSeconds
+)
+
+// WithDurationHistograms specifies the histograms used by [Recorder] (e.g via `otelchi.NewInstrumentation`)
+// for recording the request duration. Specifying both histograms is useful for migrating dashboards from
+// the legacy histogram. If none is specified, only [DurationHistogramMillis] is used.
+func WithDurationHistograms(histograms ...DurationHistogram) Option {
+	return optionFunc(func(cfg *BaseConfig) {
+		cfg.durationHistograms = histograms
+	})
+}
+
 func NewBaseConfig(serverName string, opts ...Option) BaseConfig {
 	// init base config
 	cfg := BaseConfig{
 		ServerName: serverName,
-		AttributesFunc: func(req *http.Request) []attribute.KeyValue {
-			schema := semconv.HTTPSchemeHTTP
-			if req.TLS != nil {
-				schema = semconv.HTTPSchemeHTTPS
-			}
-
-			attrs := []attribute.KeyValue{
-				semconv.HTTPMethod(req.Method),
-				schema,
-			}
+	}
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
 
-			if route := chi.RouteContext(req.Context()).RoutePattern(); route != "" {
-				attrs = append(attrs, semconv.HTTPRoute(route))
+	var resolver *forwarded.Resolver
+	if len(cfg.trustedProxies) > 0 {
+		resolver = forwarded.NewResolver(cfg.trustedProxies)
+	}
+	httpSemconv := semconvutil.NewHTTPServer(cfg.semconvMode, resolver)
+	if cfg.AttributesFunc == nil {
+		chiRoutes := cfg.chiRoutes
+		cfg.AttributesFunc = func(req *http.Request) []attribute.KeyValue {
+			route, unmatched := chiroute.Resolve(req, chiRoutes)
+			attrs := httpSemconv.MetricAttrs(req, route)
+			if unmatched != "" {
+				attrs = append(attrs, chiroute.Attr(unmatched))
 			}
-
 			return attrs
-		},
+		}
 	}
-	for _, opt := range opts {
-		opt.apply(&cfg)
+	if cfg.ResponseAttributesFunc == nil {
+		cfg.ResponseAttributesFunc = func(req *http.Request, info ResponseInfo) []attribute.KeyValue {
+			return httpSemconv.StatusCode(info.StatusCode)
+		}
 	}
 
 	if cfg.meterProvider == nil {
@@ -86,21 +210,77 @@ func NewBaseConfig(serverName string, opts ...Option) BaseConfig {
 	}
 	cfg.Meter = cfg.meterProvider.Meter(
 		ScopeName,
-		otelmetric.WithSchemaURL(semconv.SchemaURL),
+		otelmetric.WithSchemaURL(httpSemconv.SchemaURL()),
 		otelmetric.WithInstrumentationVersion(version.Version()),
 		otelmetric.WithInstrumentationAttributes(
 			semconv.ServiceName(serverName),
 		),
 	)
+	if cfg.cardinalityLimit > 0 || len(cfg.attributeCardinalityLimits) > 0 {
+		cfg.guard = newCardinalityGuard(cfg.Meter, cfg.cardinalityLimit, cfg.attributeCardinalityLimits)
+	}
 
 	return cfg
 }
 
-// [recordingResponseWriter] is a wrapper around [http.ResponseWriter] that records the number of bytes written.
+// middlewareOrNoop reports the error via `otel.Handle` & returns a middleware which only executes the next
+// handler when the middleware can't be created, so the failure of the metrics never crashes the process.
+func middlewareOrNoop(mw func(next http.Handler) http.Handler, err error) func(next http.Handler) http.Handler {
+	if err != nil {
+		otel.Handle(err)
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	return mw
+}
+
+// responseAttributes returns the attributes of the metric records recorded after the response is
+// written, it contains the attributes from AttributesFunc, ResponseAttributesFunc, [Labeler] & the
+// `error.type` of the error recorded via `otelchi.RecordError`.
+func (cfg BaseConfig) responseAttributes(req *http.Request, info ResponseInfo) []attribute.KeyValue {
+	attrs := cfg.AttributesFunc(req)
+	var respAttrs []attribute.KeyValue
+	if cfg.ResponseAttributesFunc != nil {
+		respAttrs = cfg.ResponseAttributesFunc(req, info)
+	}
+	labelerAttrs := LabelerFromContext(req.Context()).Get()
+
+	// copy the attributes so the slice returned by AttributesFunc is never modified
+	merged := make([]attribute.KeyValue, 0, len(attrs)+len(respAttrs)+len(labelerAttrs)+1)
+	merged = append(merged, attrs...)
+	merged = append(merged, respAttrs...)
+	merged = append(merged, labelerAttrs...)
+
+	// add the error recorded by the handler via `otelchi.RecordError`
+	if err := requeststate.FromContext(req.Context()).Error(); err != nil {
+		merged = append(merged, requeststate.ErrorType(err))
+	}
+	if cfg.guard != nil {
+		return cfg.guard.apply(req.Context(), merged)
+	}
+	return merged
+}
+
+// requestAttributes returns the attributes of the metric records recorded before executing the
+// handler, it contains the attributes from AttributesFunc.
+func (cfg BaseConfig) requestAttributes(req *http.Request) []attribute.KeyValue {
+	attrs := cfg.AttributesFunc(req)
+	if cfg.guard != nil {
+		return cfg.guard.apply(req.Context(), attrs)
+	}
+	return attrs
+}
+
+// [recordingResponseWriter] is a wrapper around [http.ResponseWriter] that records the number of bytes written
+// & the response status code.
 type recordingResponseWriter struct {
 	writer       http.ResponseWriter
 	written      bool
 	writtenBytes int64
+	status       int
+	panicked     bool
+	hijacked     bool
 }
 
 var rrwPool = &sync.Pool{
@@ -113,20 +293,43 @@ func getRRW(writer http.ResponseWriter) *recordingResponseWriter {
 	rrw := rrwPool.Get().(*recordingResponseWriter)
 	rrw.written = false
 	rrw.writtenBytes = 0
+	rrw.status = http.StatusOK
+	rrw.panicked = false
+	rrw.hijacked = false
 	rrw.writer = httpsnoop.Wrap(writer, httpsnoop.Hooks{
 		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
 			return func(b []byte) (int, error) {
-				if !rrw.written {
-					rrw.written = true
-					rrw.writtenBytes += int64(len(b))
+				rrw.written = true
+				n, err := next(b)
+				rrw.writtenBytes += int64(n)
+				return n, err
+			}
+		},
+		// io.Copy & http.ServeContent use ReadFrom when available (e.g sendfile),
+		// so the bytes written through it must be counted as well
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				rrw.written = true
+				n, err := next(src)
+				rrw.writtenBytes += n
+				return n, err
+			}
+		},
+		Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return func() (net.Conn, *bufio.ReadWriter, error) {
+				conn, brw, err := next()
+				if err == nil {
+					rrw.hijacked = true
 				}
-				return next(b)
+				return conn, brw, err
 			}
 		},
 		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
 			return func(statusCode int) {
-				if !rrw.written {
+				// informational responses are followed by the final one
+				if !rrw.written && !httpstatus.IsInformational(statusCode) {
 					rrw.written = true
+					rrw.status = statusCode
 				}
 				next(statusCode)
 			}
@@ -135,6 +338,28 @@ func getRRW(writer http.ResponseWriter) *recordingResponseWriter {
 	return rrw
 }
 
+// serve executes the handler using the recording response writer, it marks the response as
+// panicked when the handler doesn't return normally.
+func (rrw *recordingResponseWriter) serve(next http.Handler, r *http.Request) {
+	rrw.panicked = true
+	next.ServeHTTP(rrw.writer, r)
+	rrw.panicked = false
+}
+
+// info returns the information of the recorded response.
+func (rrw *recordingResponseWriter) info() ResponseInfo {
+	info := ResponseInfo{
+		StatusCode:   rrw.status,
+		WrittenBytes: rrw.writtenBytes,
+		Panicked:     rrw.panicked,
+		Hijacked:     rrw.hijacked,
+	}
+	if rrw.panicked && !rrw.written {
+		info.StatusCode = http.StatusInternalServerError
+	}
+	return info
+}
+
 func putRRW(rrw *recordingResponseWriter) {
 	rrw.writer = nil
 	rrwPool.Put(rrw)
@@ -0,0 +1,487 @@
+package metric_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestSemConvStabilityMode(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name     string
+		Mode     metric.SemConvStabilityMode
+		ExpAttrs []attribute.KeyValue
+	}{
+		{
+			Name: "Old Mode",
+			Mode: metric.SemConvStabilityModeOld,
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.method", "GET"),
+				attribute.String("http.scheme", "http"),
+				attribute.String("http.route", "/test"),
+				attribute.Int("http.status_code", http.StatusOK),
+			},
+		},
+		{
+			Name: "Stable Mode",
+			Mode: metric.SemConvStabilityModeStable,
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.request.method", "GET"),
+				attribute.String("url.scheme", "http"),
+				attribute.String("http.route", "/test"),
+				attribute.Int("http.response.status_code", http.StatusOK),
+			},
+		},
+		{
+			Name: "Dup Mode",
+			Mode: metric.SemConvStabilityModeDup,
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.method", "GET"),
+				attribute.String("http.scheme", "http"),
+				attribute.String("http.request.method", "GET"),
+				attribute.String("url.scheme", "http"),
+				attribute.String("http.route", "/test"),
+				attribute.Int("http.status_code", http.StatusOK),
+				attribute.Int("http.response.status_code", http.StatusOK),
+			},
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			baseCfg := metric.NewBaseConfig(
+				"test-server",
+				metric.WithMeterProvider(provider),
+				metric.WithSemConvStabilityMode(testCase.Mode),
+			)
+
+			router := chi.NewRouter()
+			router.Use(metric.NewResponseSizeBytes(baseCfg))
+			router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			// read the recorded metrics
+			var rm metricdata.ResourceMetrics
+			err := reader.Collect(context.Background(), &rm)
+			require.NoError(t, err)
+			require.Len(t, rm.ScopeMetrics, 1)
+
+			hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+			require.True(t, ok)
+			require.Len(t, hist.DataPoints, 1)
+
+			expAttrs := attribute.NewSet(testCase.ExpAttrs...)
+			assert.True(t, expAttrs.Equals(&hist.DataPoints[0].Attributes), hist.DataPoints[0].Attributes.Encoded(attribute.DefaultEncoder()))
+		})
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig(
+		"test-server",
+		metric.WithMeterProvider(provider),
+		metric.WithSemConvStabilityMode(metric.SemConvStabilityModeStable),
+		metric.WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+	)
+
+	router := chi.NewRouter()
+	router.Use(metric.NewResponseSizeBytes(baseCfg))
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// execute requests, only the first one comes from trusted proxy
+	for _, remoteAddr := range []string{"10.0.0.1:1234", "192.0.2.1:1234"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Proto", "https")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// read the recorded metrics
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 2)
+
+	schemes := []string{}
+	for _, dp := range hist.DataPoints {
+		scheme, _ := dp.Attributes.Value("url.scheme")
+		schemes = append(schemes, scheme.AsString())
+	}
+	assert.ElementsMatch(t, []string{"https", "http"}, schemes)
+}
+
+func TestResponseAttributes(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name          string
+		Handler       http.HandlerFunc
+		ExpStatusCode int
+		ExpPanicked   bool
+	}{
+		{
+			Name: "Implicit OK",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			},
+			ExpStatusCode: http.StatusOK,
+		},
+		{
+			Name: "Server Error",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			ExpStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			Name: "Panic Before Write",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("something went wrong")
+			},
+			ExpStatusCode: http.StatusInternalServerError,
+			ExpPanicked:   true,
+		},
+		{
+			Name: "Panic After Write",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("something went wrong")
+			},
+			ExpStatusCode: http.StatusAccepted,
+			ExpPanicked:   true,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			var info metric.ResponseInfo
+			baseCfg := metric.NewBaseConfig(
+				"test-server",
+				metric.WithMeterProvider(provider),
+				metric.WithSemConvStabilityMode(metric.SemConvStabilityModeStable),
+				metric.WithResponseAttributesFunc(func(req *http.Request, i metric.ResponseInfo) []attribute.KeyValue {
+					info = i
+					return []attribute.KeyValue{
+						attribute.Int("http.response.status_code", i.StatusCode),
+					}
+				}),
+			)
+
+			router := chi.NewRouter()
+			router.Use(metric.NewRequestDurationMillis(baseCfg))
+			router.Get("/test", testCase.Handler)
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			serve := func() { router.ServeHTTP(httptest.NewRecorder(), req) }
+			if testCase.ExpPanicked {
+				require.Panics(t, serve)
+			} else {
+				serve()
+			}
+
+			assert.Equal(t, testCase.ExpStatusCode, info.StatusCode)
+			assert.Equal(t, testCase.ExpPanicked, info.Panicked)
+
+			// read the recorded metrics
+			var rm metricdata.ResourceMetrics
+			err := reader.Collect(context.Background(), &rm)
+			require.NoError(t, err)
+			require.Len(t, rm.ScopeMetrics, 1)
+
+			hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+			require.True(t, ok)
+			require.Len(t, hist.DataPoints, 1)
+
+			statusCode, ok := hist.DataPoints[0].Attributes.Value("http.response.status_code")
+			require.True(t, ok)
+			assert.Equal(t, int64(testCase.ExpStatusCode), statusCode.AsInt64())
+		})
+	}
+}
+
+func TestInstrumentCreationError(t *testing.T) {
+	// capture the errors reported via otel.Handle
+	var handledErrs []error
+	defer otel.SetErrorHandler(otel.GetErrorHandler())
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		handledErrs = append(handledErrs, err)
+	}))
+
+	baseCfg := metric.NewBaseConfig("test-server")
+	baseCfg.Meter = failingMeter{}
+
+	// prepare test cases
+	testCases := []struct {
+		Name        string
+		Middleware  func(cfg metric.BaseConfig) func(next http.Handler) http.Handler
+		MiddlewareE func(cfg metric.BaseConfig) (func(next http.Handler) http.Handler, error)
+	}{
+		{
+			Name:        "Request Duration Millis",
+			Middleware:  metric.NewRequestDurationMillis,
+			MiddlewareE: metric.NewRequestDurationMillisE,
+		},
+		{
+			Name:        "Request Duration",
+			Middleware:  metric.NewRequestDuration,
+			MiddlewareE: metric.NewRequestDurationE,
+		},
+		{
+			Name:        "Request In Flight",
+			Middleware:  metric.NewRequestInFlight,
+			MiddlewareE: metric.NewRequestInFlightE,
+		},
+		{
+			Name:        "Response Size Bytes",
+			Middleware:  metric.NewResponseSizeBytes,
+			MiddlewareE: metric.NewResponseSizeBytesE,
+		},
+		{
+			Name:        "Request Body Size",
+			Middleware:  metric.NewRequestBodySize,
+			MiddlewareE: metric.NewRequestBodySizeE,
+		},
+		{
+			Name:        "Requests Aborted",
+			Middleware:  metric.NewRequestsAborted,
+			MiddlewareE: metric.NewRequestsAbortedE,
+		},
+		{
+			Name:        "Requests Total",
+			Middleware:  metric.NewRequestsTotal,
+			MiddlewareE: metric.NewRequestsTotalE,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			handledErrs = nil
+
+			// the error is returned by the E variant
+			mw, err := testCase.MiddlewareE(baseCfg)
+			assert.ErrorIs(t, err, errInstrument)
+			assert.Nil(t, mw)
+
+			// the error is reported & the handler is still executed
+			var called bool
+			router := chi.NewRouter()
+			router.Use(testCase.Middleware(baseCfg))
+			router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			assert.True(t, called)
+			require.Len(t, handledErrs, 1)
+			assert.ErrorIs(t, handledErrs[0], errInstrument)
+		})
+	}
+
+	t.Run("Recorder", func(t *testing.T) {
+		handledErrs = nil
+
+		rec, err := metric.NewRecorderE(baseCfg)
+		assert.ErrorIs(t, err, errInstrument)
+		assert.Nil(t, rec)
+
+		// the returned recorder doesn't record anything but is safe to use
+		rec = metric.NewRecorder(baseCfg)
+		require.NotNil(t, rec)
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		rec.End(req, rec.Start(req), metric.RequestSummary{StatusCode: http.StatusOK})
+
+		require.Len(t, handledErrs, 1)
+		assert.ErrorIs(t, handledErrs[0], errInstrument)
+	})
+}
+
+var errInstrument = errors.New("instrument creation failed")
+
+// failingMeter is a meter which always fails to create the instruments.
+type failingMeter struct {
+	noop.Meter
+}
+
+func (failingMeter) Int64Histogram(string, ...otelmetric.Int64HistogramOption) (otelmetric.Int64Histogram, error) {
+	return nil, errInstrument
+}
+
+func (failingMeter) Float64Histogram(string, ...otelmetric.Float64HistogramOption) (otelmetric.Float64Histogram, error) {
+	return nil, errInstrument
+}
+
+func (failingMeter) Int64UpDownCounter(string, ...otelmetric.Int64UpDownCounterOption) (otelmetric.Int64UpDownCounter, error) {
+	return nil, errInstrument
+}
+
+func TestUnknownMethod(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig(
+		"test-server",
+		metric.WithMeterProvider(provider),
+		metric.WithSemConvStabilityMode(metric.SemConvStabilityModeDup),
+	)
+
+	router := chi.NewRouter()
+	router.Use(metric.NewResponseSizeBytes(baseCfg))
+	router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {})
+
+	// unknown methods should be recorded in the same series
+	for _, method := range []string{"FOO", "BAR"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/test", nil))
+	}
+
+	// read the recorded metrics
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+
+	for _, key := range []attribute.Key{"http.method", "http.request.method"} {
+		method, ok := hist.DataPoints[0].Attributes.Value(key)
+		require.True(t, ok)
+		assert.Equal(t, metric.OtherValue, method.AsString())
+	}
+}
+
+func TestUnmatchedRoute(t *testing.T) {
+	for _, withChiRoutes := range []bool{false, true} {
+		t.Run(fmt.Sprintf("With Chi Routes %v", withChiRoutes), func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			router := chi.NewRouter()
+			opts := []metric.Option{metric.WithMeterProvider(provider)}
+			if withChiRoutes {
+				opts = append(opts, metric.WithChiRoutes(router))
+			}
+			baseCfg := metric.NewBaseConfig("test-server", opts...)
+
+			router.Use(metric.NewResponseSizeBytes(baseCfg))
+			router.Get("/test", func(w http.ResponseWriter, r *http.Request) {})
+			router.Get("/item/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+			// execute matched, not found & method not allowed requests
+			for _, req := range []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/test", nil),
+				httptest.NewRequest(http.MethodGet, "/item/a%2Fb", nil),
+				httptest.NewRequest(http.MethodGet, "/unknown", nil),
+				httptest.NewRequest(http.MethodPost, "/test", nil),
+			} {
+				router.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			// read the recorded metrics
+			var rm metricdata.ResourceMetrics
+			err := reader.Collect(context.Background(), &rm)
+			require.NoError(t, err)
+			require.Len(t, rm.ScopeMetrics, 1)
+
+			hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+			require.True(t, ok)
+
+			series := []string{}
+			for _, dp := range hist.DataPoints {
+				route, _ := dp.Attributes.Value("http.route")
+				unmatched, _ := dp.Attributes.Value(metric.UnmatchedRouteKey)
+				series = append(series, route.AsString()+"|"+unmatched.AsString())
+			}
+			assert.ElementsMatch(t, []string{"/test|", "/item/{id}|", "|not_found", "|method_not_allowed"}, series)
+		})
+	}
+}
+
+func (failingMeter) Int64Counter(string, ...otelmetric.Int64CounterOption) (otelmetric.Int64Counter, error) {
+	return nil, errInstrument
+}
+
+func TestInformationalResponse(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig(
+		"test-server",
+		metric.WithMeterProvider(provider),
+		metric.WithSemConvStabilityMode(metric.SemConvStabilityModeDup),
+	)
+
+	router := chi.NewRouter()
+	router.Use(metric.NewResponseSizeBytes(baseCfg))
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// use real server so the informational response is sent to the client
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/test")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// close the server so the handler is guaranteed to be finished
+	ts.Close()
+
+	// read the recorded metrics
+	var rm metricdata.ResourceMetrics
+	err = reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+
+	// the final status should be recorded instead of the informational one
+	for _, key := range []attribute.Key{"http.status_code", "http.response.status_code"} {
+		status, ok := hist.DataPoints[0].Attributes.Value(key)
+		require.True(t, ok, key)
+		assert.Equal(t, int64(http.StatusCreated), status.AsInt64(), key)
+	}
+}
@@ -0,0 +1,11 @@
+// Package metric provides middlewares for recording the metrics of the
+// requests served by chi.
+//
+// The middleware constructors never fail, when the metric instrument can't be
+// created the error is reported via `otel.Handle` & the returned middleware
+// only executes the next handler. Use the constructors with `E` suffix for
+// handling the error by yourself.
+//
+// The measurements taken at the end of the request are recorded in deferred
+// functions, so the requests whose handler panicked are recorded as well.
+package metric
@@ -0,0 +1,65 @@
+package metric
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// [Labeler] is used by the handlers to add attributes into the metric records of the request, e.g the
+// tenant or the cache outcome which are only known by the handler. The metric middlewares inject it into
+// the request context & merge the added attributes into the records of the request duration, the
+// response size & the request body size. It is safe for concurrent use.
+type Labeler struct {
+	mu         sync.Mutex
+	attributes []attribute.KeyValue
+}
+
+// Add attributes to the [Labeler].
+func (l *Labeler) Add(attrs ...attribute.KeyValue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attributes = append(l.attributes, attrs...)
+}
+
+// Get returns a copy of the attributes added to the [Labeler].
+func (l *Labeler) Get() []attribute.KeyValue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := make([]attribute.KeyValue, len(l.attributes))
+	copy(ret, l.attributes)
+	return ret
+}
+
+type labelerContextKey struct{}
+
+// ContextWithLabeler returns a new context with the provided [Labeler].
+func ContextWithLabeler(parent context.Context, l *Labeler) context.Context {
+	return context.WithValue(parent, labelerContextKey{}, l)
+}
+
+// LabelerFromContext returns the [Labeler] injected by the metric middlewares. When there is none, a new
+// [Labeler] is returned so it is always safe to add attributes, but they are not recorded anywhere.
+func LabelerFromContext(ctx context.Context) *Labeler {
+	l, ok := ctx.Value(labelerContextKey{}).(*Labeler)
+	if !ok {
+		l = &Labeler{}
+	}
+	return l
+}
+
+// withRequestScope returns the request with [Labeler] & the request state in its context, the existing
+// ones are reused so the stacked metric middlewares share the same ones.
+func withRequestScope(r *http.Request) *http.Request {
+	ctx, _ := requeststate.Ensure(r.Context())
+	if _, ok := ctx.Value(labelerContextKey{}).(*Labeler); !ok {
+		ctx = ContextWithLabeler(ctx, &Labeler{})
+	}
+	if ctx == r.Context() {
+		return r
+	}
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,109 @@
+package metric_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestLabeler(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+
+	router := chi.NewRouter()
+	router.Use(
+		metric.NewRequestDurationMillis(baseCfg),
+		metric.NewResponseSizeBytes(baseCfg),
+	)
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		metric.LabelerFromContext(r.Context()).Add(attribute.String("tenant", r.URL.Query().Get("tenant")))
+		w.Write([]byte("OK"))
+	})
+
+	// execute requests for different tenants
+	for _, tenant := range []string{"foo", "bar", "foo"} {
+		req := httptest.NewRequest(http.MethodGet, "/test?tenant="+tenant, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// read the recorded metrics
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	// every metric should be split by the tenant
+	metrics := rm.ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 2)
+	for _, m := range metrics {
+		hist, ok := m.Data.(metricdata.Histogram[int64])
+		require.True(t, ok)
+		require.Len(t, hist.DataPoints, 2, m.Name)
+
+		counts := map[string]uint64{}
+		for _, dp := range hist.DataPoints {
+			tenant, ok := dp.Attributes.Value("tenant")
+			require.True(t, ok)
+			counts[tenant.AsString()] = dp.Count
+
+			_, ok = dp.Attributes.Value("http.route")
+			assert.True(t, ok)
+		}
+		assert.Equal(t, map[string]uint64{"foo": 2, "bar": 1}, counts, m.Name)
+	}
+}
+
+func TestLabelerFromContextWithoutMiddleware(t *testing.T) {
+	// the labeler should be safe to use even without the metric middleware
+	labeler := metric.LabelerFromContext(context.Background())
+	labeler.Add(attribute.String("tenant", "foo"))
+	assert.Equal(t, []attribute.KeyValue{attribute.String("tenant", "foo")}, labeler.Get())
+
+	// the labeler in the context is returned
+	labeler = &metric.Labeler{}
+	ctx := metric.ContextWithLabeler(context.Background(), labeler)
+	assert.Same(t, labeler, metric.LabelerFromContext(ctx))
+}
+
+func TestRecordErrorType(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+
+	router := chi.NewRouter()
+	router.Use(metric.NewResponseSizeBytes(baseCfg))
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		otelchi.RecordError(r, context.DeadlineExceeded)
+		w.WriteHeader(http.StatusGatewayTimeout)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	// read the recorded metrics
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+
+	errorType, ok := hist.DataPoints[0].Attributes.Value("error.type")
+	require.True(t, ok)
+	assert.Equal(t, "context.deadlineExceededError", errorType.AsString())
+}
@@ -0,0 +1,136 @@
+package metric
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// [Recorder] records every metric instrument of this package for a single request. Unlike the
+// middlewares in this package, it doesn't wrap the response writer by itself. Instead, it is fed by
+// the caller which already wraps the response writer, e.g `otelchi.NewInstrumentation`, so the
+// response writer is only wrapped once per request.
+type Recorder struct {
+	cfg BaseConfig
+
+	requestDurationMillis otelmetric.Int64Histogram
+	requestDuration       otelmetric.Float64Histogram
+	requestInFlight       otelmetric.Int64UpDownCounter
+	responseSizeBytes     otelmetric.Int64Histogram
+	requestBodySize       otelmetric.Int64Histogram
+	requestsAborted       otelmetric.Int64Counter
+	requestsTotal         otelmetric.Int64Counter
+}
+
+// [RequestSummary] contains the measurements of a finished request.
+type RequestSummary struct {
+	// Duration is the time elapsed since the request started.
+	Duration time.Duration
+	// StatusCode is the response status code.
+	StatusCode int
+	// ReadBytes is the number of bytes read from the request body, the `Content-Length` is used when
+	// the handler doesn't read the body.
+	ReadBytes int64
+	// WrittenBytes is the number of bytes written into the response body.
+	WrittenBytes int64
+	// Panicked is true when the handler panicked.
+	Panicked bool
+	// Hijacked is true when the handler hijacked the connection.
+	Hijacked bool
+	// Aborted is the reason why the request is aborted before the handler returns, it is
+	// either `client_cancelled` or `deadline_exceeded`. It is empty when the request is not aborted.
+	Aborted string
+}
+
+// NewRecorder returns [Recorder] for the given config. When the metric instruments can't be created,
+// the error is reported via `otel.Handle` & the returned recorder doesn't record anything.
+func NewRecorder(cfg BaseConfig) *Recorder {
+	rec, err := NewRecorderE(cfg)
+	if err != nil {
+		otel.Handle(err)
+
+		// the noop meter never fails to create the instruments
+		cfg.Meter = noop.Meter{}
+		rec, _ = NewRecorderE(cfg)
+	}
+	return rec
+}
+
+// [NewRecorderE] is like [NewRecorder] but returns the instrument creation error.
+func NewRecorderE(cfg BaseConfig) (*Recorder, error) {
+	rec := &Recorder{cfg: cfg}
+
+	histograms := cfg.durationHistograms
+	if len(histograms) == 0 {
+		histograms = []DurationHistogram{DurationHistogramMillis}
+	}
+
+	var err error
+	for _, histogram := range histograms {
+		switch histogram {
+		case DurationHistogramMillis:
+			rec.requestDurationMillis, err = newRequestDurationMillis(cfg.Meter)
+		case DurationHistogramSeconds:
+			rec.requestDuration, err = newRequestDuration(cfg.Meter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rec.requestInFlight, err = newRequestInFlight(cfg.Meter); err != nil {
+		return nil, err
+	}
+	if rec.responseSizeBytes, err = newResponseSizeBytes(cfg.Meter); err != nil {
+		return nil, err
+	}
+	if rec.requestBodySize, err = newRequestBodySize(cfg.Meter); err != nil {
+		return nil, err
+	}
+	if rec.requestsAborted, err = newRequestsAborted(cfg.Meter); err != nil {
+		return nil, err
+	}
+	if rec.requestsTotal, err = newRequestsTotal(cfg.Meter); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Start records the request as in flight, it must be called before executing the handler. The
+// returned option must be passed to [Recorder.End] so the in flight counter is decreased & the request
+// is counted using the identical attribute set.
+func (rec *Recorder) Start(r *http.Request) otelmetric.MeasurementOption {
+	attrs := otelmetric.WithAttributeSet(attribute.NewSet(rec.cfg.requestAttributes(r)...))
+	rec.requestInFlight.Add(r.Context(), 1, attrs)
+	return attrs
+}
+
+// End records the measurements of the finished request, it must be called after the handler
+// returns, including when the handler panics.
+func (rec *Recorder) End(r *http.Request, startAttrs otelmetric.MeasurementOption, summary RequestSummary) {
+	ctx := r.Context()
+	rec.requestInFlight.Add(ctx, -1, startAttrs)
+	rec.requestsTotal.Add(ctx, 1, startAttrs)
+
+	// the attributes are evaluated once for every instrument
+	attrs := otelmetric.WithAttributeSet(attribute.NewSet(rec.cfg.responseAttributes(r, ResponseInfo{
+		StatusCode:   summary.StatusCode,
+		WrittenBytes: summary.WrittenBytes,
+		Panicked:     summary.Panicked,
+		Hijacked:     summary.Hijacked,
+	})...))
+	if rec.requestDurationMillis != nil {
+		rec.requestDurationMillis.Record(ctx, summary.Duration.Milliseconds(), attrs)
+	}
+	if rec.requestDuration != nil {
+		rec.requestDuration.Record(ctx, summary.Duration.Seconds(), attrs)
+	}
+	rec.responseSizeBytes.Record(ctx, summary.WrittenBytes, attrs)
+	rec.requestBodySize.Record(ctx, summary.ReadBytes, attrs)
+	if summary.Aborted != "" {
+		rec.cfg.recordAborted(r, rec.requestsAborted, summary.Aborted)
+	}
+}
@@ -0,0 +1,80 @@
+package metric_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRecorderDurationHistograms(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name       string
+		Opts       []metric.Option
+		ExpMetrics []string
+	}{
+		{
+			Name:       "Default",
+			ExpMetrics: []string{"request_duration_millis", "requests_inflight", "requests_total", "response_size_bytes", "http.server.request.body.size"},
+		},
+		{
+			Name:       "Seconds Only",
+			Opts:       []metric.Option{metric.WithDurationHistograms(metric.DurationHistogramSeconds)},
+			ExpMetrics: []string{"http.server.request.duration", "requests_inflight", "requests_total", "response_size_bytes", "http.server.request.body.size"},
+		},
+		{
+			Name: "Both",
+			Opts: []metric.Option{
+				metric.WithDurationHistograms(metric.DurationHistogramMillis, metric.DurationHistogramSeconds),
+			},
+			ExpMetrics: []string{"request_duration_millis", "http.server.request.duration", "requests_inflight", "requests_total", "response_size_bytes", "http.server.request.body.size"},
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			opts := append([]metric.Option{metric.WithMeterProvider(provider)}, testCase.Opts...)
+			recorder := metric.NewRecorder(metric.NewBaseConfig("test-server", opts...))
+
+			// record a single request
+			req := httptest.NewRequest("GET", "/test", nil)
+			startAttrs := recorder.Start(req)
+			recorder.End(req, startAttrs, metric.RequestSummary{
+				Duration:     1500 * time.Microsecond,
+				ReadBytes:    5,
+				WrittenBytes: 10,
+			})
+
+			// read the recorded metrics
+			var rm metricdata.ResourceMetrics
+			err := reader.Collect(context.Background(), &rm)
+			require.NoError(t, err)
+			require.Len(t, rm.ScopeMetrics, 1)
+
+			names := []string{}
+			for _, m := range rm.ScopeMetrics[0].Metrics {
+				names = append(names, m.Name)
+				switch m.Name {
+				case "request_duration_millis":
+					assert.Equal(t, int64(1), m.Data.(metricdata.Histogram[int64]).DataPoints[0].Sum)
+				case "http.server.request.duration":
+					assert.Equal(t, 0.0015, m.Data.(metricdata.Histogram[float64]).DataPoints[0].Sum)
+				case "http.server.request.body.size":
+					assert.Equal(t, int64(5), m.Data.(metricdata.Histogram[int64]).DataPoints[0].Sum)
+				}
+			}
+			assert.ElementsMatch(t, testCase.ExpMetrics, names)
+		})
+	}
+}
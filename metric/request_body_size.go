@@ -0,0 +1,73 @@
+package metric
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/riandyrn/otelchi/internal/bodysize"
+
+	otelmetric "go.opentelemetry.io/otel/metric"
+)
+
+const (
+	metricNameRequestBodySize = "http.server.request.body.size"
+	metricUnitRequestBodySize = "By"
+	metricDescRequestBodySize = "Size of HTTP server request bodies."
+)
+
+// [NewRequestBodySize] is a metrics recorder for recording the request body size using the semantic
+// conventions `http.server.request.body.size` histogram. The size is the number of bytes actually read
+// by the handler, when the handler doesn't read the body the `Content-Length` header is used instead.
+func NewRequestBodySize(cfg BaseConfig) func(next http.Handler) http.Handler {
+	return middlewareOrNoop(NewRequestBodySizeE(cfg))
+}
+
+// [NewRequestBodySizeE] is like [NewRequestBodySize] but returns the instrument creation error.
+func NewRequestBodySizeE(cfg BaseConfig) (func(next http.Handler) http.Handler, error) {
+	// init metric, here we are using histogram for capturing request body size
+	histogram, err := newRequestBodySize(cfg.Meter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// inject labeler so the handler could add attributes into the record
+			r = withRequestScope(r)
+
+			// wrap request body for counting the bytes read by the handler
+			r, body := bodysize.Wrap(r, nil)
+
+			// get recording response writer
+			rrw := getRRW(w)
+			defer putRRW(rrw)
+
+			// record the request body size
+			defer func() {
+				size, _ := bodysize.Consumed(r, body)
+				histogram.Record(
+					r.Context(),
+					size,
+					otelmetric.WithAttributes(
+						cfg.responseAttributes(r, rrw.info())...,
+					),
+				)
+			}()
+
+			// execute next http handler
+			rrw.serve(next, r)
+		})
+	}, nil
+}
+
+func newRequestBodySize(meter otelmetric.Meter) (otelmetric.Int64Histogram, error) {
+	histogram, err := meter.Int64Histogram(
+		metricNameRequestBodySize,
+		otelmetric.WithDescription(metricDescRequestBodySize),
+		otelmetric.WithUnit(metricUnitRequestBodySize),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s histogram: %w", metricNameRequestBodySize, err)
+	}
+	return histogram, nil
+}
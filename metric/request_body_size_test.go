@@ -0,0 +1,107 @@
+package metric_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRequestBodySize(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name          string
+		Body          io.Reader
+		ContentLength int64
+		Handler       http.HandlerFunc
+		ExpSize       int64
+	}{
+		{
+			Name: "Body Read By Handler",
+			Body: strings.NewReader("Hello, World!"),
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+			},
+			ExpSize: int64(len("Hello, World!")),
+		},
+		{
+			Name: "Body Partially Read By Handler",
+			Body: strings.NewReader("Hello, World!"),
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				io.ReadFull(r.Body, make([]byte, 5))
+			},
+			ExpSize: 5,
+		},
+		{
+			Name: "Unknown Length Body Read By Handler",
+			Body: io.MultiReader(strings.NewReader("Hello, World!")),
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+			},
+			ContentLength: -1,
+			ExpSize:       int64(len("Hello, World!")),
+		},
+		{
+			Name:    "Body Not Read By Handler",
+			Body:    strings.NewReader("Hello, World!"),
+			Handler: func(w http.ResponseWriter, r *http.Request) {},
+			ExpSize: int64(len("Hello, World!")),
+		},
+		{
+			Name:    "No Body",
+			Handler: func(w http.ResponseWriter, r *http.Request) {},
+			ExpSize: 0,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+
+			router := chi.NewRouter()
+			router.Use(metric.NewRequestBodySize(baseCfg))
+			router.Post("/upload", testCase.Handler)
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", testCase.Body)
+			if testCase.ContentLength != 0 {
+				req.ContentLength = testCase.ContentLength
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			// read the recorded metrics
+			var rm metricdata.ResourceMetrics
+			err := reader.Collect(context.Background(), &rm)
+			require.NoError(t, err)
+			require.Len(t, rm.ScopeMetrics, 1)
+
+			metrics := rm.ScopeMetrics[0].Metrics
+			require.Len(t, metrics, 1)
+			assert.Equal(t, "http.server.request.body.size", metrics[0].Name)
+
+			hist, ok := metrics[0].Data.(metricdata.Histogram[int64])
+			require.True(t, ok)
+			require.Len(t, hist.DataPoints, 1)
+
+			dp := hist.DataPoints[0]
+			assert.Equal(t, testCase.ExpSize, dp.Sum)
+			assert.Equal(t, uint64(1), dp.Count)
+
+			route, ok := dp.Attributes.Value("http.route")
+			require.True(t, ok)
+			assert.Equal(t, "/upload", route.AsString())
+		})
+	}
+}
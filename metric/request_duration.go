@@ -14,15 +14,17 @@ const (
 	metricDescRequestDurationMs = "Measures the latency of HTTP requests processed by the server, in milliseconds."
 )
 
+// [NewRequestDurationMillis] is a metrics recorder for recording the request duration in milliseconds.
 func NewRequestDurationMillis(cfg BaseConfig) func(next http.Handler) http.Handler {
+	return middlewareOrNoop(NewRequestDurationMillisE(cfg))
+}
+
+// [NewRequestDurationMillisE] is like [NewRequestDurationMillis] but returns the instrument creation error.
+func NewRequestDurationMillisE(cfg BaseConfig) (func(next http.Handler) http.Handler, error) {
 	// init metric, here we are using histogram for capturing request duration
-	histogram, err := cfg.Meter.Int64Histogram(
-		metricNameRequestDurationMs,
-		otelmetric.WithDescription(metricDescRequestDurationMs),
-		otelmetric.WithUnit(metricUnitRequestDurationMs),
-	)
+	histogram, err := newRequestDurationMillis(cfg.Meter)
 	if err != nil {
-		panic(fmt.Sprintf("unable to create %s histogram: %v", metricNameRequestDurationMs, err))
+		return nil, err
 	}
 
 	return func(next http.Handler) http.Handler {
@@ -30,18 +32,39 @@ func NewRequestDurationMillis(cfg BaseConfig) func(next http.Handler) http.Handl
 			// capture the start time of the request
 			startTime := time.Now()
 
-			// execute next http handler
-			next.ServeHTTP(w, r)
+			// inject labeler so the handler could add attributes into the record
+			r = withRequestScope(r)
+
+			// get recording response writer
+			rrw := getRRW(w)
+			defer putRRW(rrw)
 
 			// record the request duration
-			duration := time.Since(startTime)
-			histogram.Record(
-				r.Context(),
-				int64(duration.Milliseconds()),
-				otelmetric.WithAttributes(
-					cfg.AttributesFunc(r)...,
-				),
-			)
+			defer func() {
+				duration := time.Since(startTime)
+				histogram.Record(
+					r.Context(),
+					int64(duration.Milliseconds()),
+					otelmetric.WithAttributes(
+						cfg.responseAttributes(r, rrw.info())...,
+					),
+				)
+			}()
+
+			// execute next http handler
+			rrw.serve(next, r)
 		})
+	}, nil
+}
+
+func newRequestDurationMillis(meter otelmetric.Meter) (otelmetric.Int64Histogram, error) {
+	histogram, err := meter.Int64Histogram(
+		metricNameRequestDurationMs,
+		otelmetric.WithDescription(metricDescRequestDurationMs),
+		otelmetric.WithUnit(metricUnitRequestDurationMs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s histogram: %w", metricNameRequestDurationMs, err)
 	}
+	return histogram, nil
 }
@@ -0,0 +1,79 @@
+package metric
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	otelmetric "go.opentelemetry.io/otel/metric"
+)
+
+const (
+	metricNameRequestDuration = "http.server.request.duration"
+	metricUnitRequestDuration = "s"
+	metricDescRequestDuration = "Duration of HTTP server requests."
+)
+
+// metricBucketsRequestDuration is the explicit bucket boundaries recommended by the semantic conventions.
+//
+// See https://opentelemetry.io/docs/specs/semconv/http/http-metrics/#metric-httpserverrequestduration for details.
+var metricBucketsRequestDuration = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10,
+}
+
+// [NewRequestDuration] is a metrics recorder for recording the request duration using the semantic
+// conventions compliant `http.server.request.duration` histogram. Unlike [NewRequestDurationMillis],
+// the duration is recorded in seconds as float, so sub-millisecond requests are not recorded as 0.
+func NewRequestDuration(cfg BaseConfig) func(next http.Handler) http.Handler {
+	return middlewareOrNoop(NewRequestDurationE(cfg))
+}
+
+// [NewRequestDurationE] is like [NewRequestDuration] but returns the instrument creation error.
+func NewRequestDurationE(cfg BaseConfig) (func(next http.Handler) http.Handler, error) {
+	// init metric, here we are using histogram for capturing request duration
+	histogram, err := newRequestDuration(cfg.Meter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// capture the start time of the request
+			startTime := time.Now()
+
+			// inject labeler so the handler could add attributes into the record
+			r = withRequestScope(r)
+
+			// get recording response writer
+			rrw := getRRW(w)
+			defer putRRW(rrw)
+
+			// record the request duration
+			defer func() {
+				histogram.Record(
+					r.Context(),
+					time.Since(startTime).Seconds(),
+					otelmetric.WithAttributes(
+						cfg.responseAttributes(r, rrw.info())...,
+					),
+				)
+			}()
+
+			// execute next http handler
+			rrw.serve(next, r)
+		})
+	}, nil
+}
+
+func newRequestDuration(meter otelmetric.Meter) (otelmetric.Float64Histogram, error) {
+	histogram, err := meter.Float64Histogram(
+		metricNameRequestDuration,
+		otelmetric.WithDescription(metricDescRequestDuration),
+		otelmetric.WithUnit(metricUnitRequestDuration),
+		otelmetric.WithExplicitBucketBoundaries(metricBucketsRequestDuration...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s histogram: %w", metricNameRequestDuration, err)
+	}
+	return histogram, nil
+}
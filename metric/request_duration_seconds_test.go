@@ -0,0 +1,57 @@
+package metric_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRequestDuration(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+	middleware := metric.NewRequestDuration(baseCfg)
+
+	router := chi.NewRouter()
+	router.Use(middleware)
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		// sub-millisecond request
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	// read the recorded metrics
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	metrics := rm.ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "http.server.request.duration", metrics[0].Name)
+	assert.Equal(t, "s", metrics[0].Unit)
+
+	hist, ok := metrics[0].Data.(metricdata.Histogram[float64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+
+	dp := hist.DataPoints[0]
+	assert.Greater(t, dp.Sum, float64(0))
+	assert.Less(t, dp.Sum, 0.005)
+	assert.Equal(t, uint64(1), dp.Count)
+	assert.Equal(t, []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}, dp.Bounds)
+}
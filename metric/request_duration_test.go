@@ -54,3 +54,35 @@ func TestRequestDurationMillis(t *testing.T) {
 	assert.GreaterOrEqual(t, dp.Sum, int64(expLatencyInMillis))
 	assert.Equal(t, uint64(1), dp.Count)
 }
+
+func TestRequestDurationMillisPanic(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+	middleware := metric.NewRequestDurationMillis(baseCfg)
+
+	router := chi.NewRouter()
+	router.Use(middleware)
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	rec := httptest.NewRecorder()
+
+	// execute the request
+	require.Panics(t, func() { router.ServeHTTP(rec, req) })
+
+	// the panicked request should still be recorded
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+	assert.Equal(t, uint64(1), hist.DataPoints[0].Count)
+}
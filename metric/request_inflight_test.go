@@ -2,12 +2,14 @@ package metric_test
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/metric/metricdata"
@@ -64,3 +66,87 @@ func getCountRequestInFlight(t *testing.T, reader *sdkmetric.ManualReader) int64
 	dp := dps.DataPoints[0]
 	return dp.Value
 }
+
+func TestRequestInflightPanic(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+	middleware := metric.NewRequestInFlight(baseCfg)
+
+	router := chi.NewRouter()
+	router.Use(middleware)
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	rec := httptest.NewRecorder()
+
+	// execute the request
+	require.Panics(t, func() { router.ServeHTTP(rec, req) })
+
+	// the inflight request should be back to 0
+	require.Equal(t, int64(0), getCountRequestInFlight(t, reader))
+}
+
+func TestRequestInflightWithChiRoutes(t *testing.T) {
+	// without chi routes, the routes in the route context are used
+	for _, withChiRoutes := range []bool{false, true} {
+		t.Run(fmt.Sprintf("With Chi Routes %v", withChiRoutes), func(t *testing.T) {
+			// setup environment
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			router := chi.NewRouter()
+			opts := []metric.Option{metric.WithMeterProvider(provider)}
+			if withChiRoutes {
+				opts = append(opts, metric.WithChiRoutes(router))
+			}
+			baseCfg := metric.NewBaseConfig("test-server", opts...)
+
+			// the middleware is registered on the mounted router, so the route
+			// pattern in the route context is only partial at request start
+			router.Route("/users", func(r chi.Router) {
+				r.Use(metric.NewRequestInFlight(baseCfg))
+				r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+					// the inflight request should contain the full route pattern
+					dps := getDataPointsRequestInFlight(t, reader)
+					require.Len(t, dps, 1)
+					route, ok := dps[0].Attributes.Value("http.route")
+					require.True(t, ok)
+					assert.Equal(t, "/users/{id}", route.AsString())
+					assert.Equal(t, int64(1), dps[0].Value)
+
+					w.WriteHeader(http.StatusOK)
+				})
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/users/123", nil)
+			rec := httptest.NewRecorder()
+
+			// execute the request
+			router.ServeHTTP(rec, req)
+
+			// the inflight request should be decreased on the same series
+			dps := getDataPointsRequestInFlight(t, reader)
+			require.Len(t, dps, 1)
+			assert.Equal(t, int64(0), dps[0].Value)
+		})
+	}
+}
+
+func getDataPointsRequestInFlight(t *testing.T, reader *sdkmetric.ManualReader) []metricdata.DataPoint[int64] {
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	metrics := rm.ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 1)
+
+	sum, ok := metrics[0].Data.(metricdata.Sum[int64])
+	require.True(t, ok)
+	return sum.DataPoints
+}
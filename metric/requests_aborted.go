@@ -0,0 +1,71 @@
+package metric
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	otelmetric "go.opentelemetry.io/otel/metric"
+)
+
+const (
+	metricNameRequestsAborted = "requests_aborted"
+	metricUnitRequestsAborted = "{request}"
+	metricDescRequestsAborted = "Measures the number of requests aborted by the client or by the deadline before the handler returns."
+)
+
+// AbortedKey is the attribute key of `requests_aborted` counter containing the reason why the request is
+// aborted, the value is either `client_cancelled` or `deadline_exceeded`.
+const AbortedKey = requeststate.AbortedKey
+
+// [NewRequestsAborted] is a metrics recorder for counting the requests whose context is done when the handler
+// returns, i.e the client went away or the deadline set by e.g chi `middleware.Timeout` is exceeded. The
+// deadline is only visible when this middleware is registered after (inside) the timeout middleware.
+func NewRequestsAborted(cfg BaseConfig) func(next http.Handler) http.Handler {
+	return middlewareOrNoop(NewRequestsAbortedE(cfg))
+}
+
+// [NewRequestsAbortedE] is like [NewRequestsAborted] but returns the instrument creation error.
+func NewRequestsAbortedE(cfg BaseConfig) (func(next http.Handler) http.Handler, error) {
+	// init metric, here we are using counter for capturing aborted requests
+	counter, err := newRequestsAborted(cfg.Meter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// share the request state so the route is only resolved once
+			r = withRequestScope(r)
+
+			// record the aborted request
+			defer func() {
+				if reason := requeststate.AbortReason(r.Context()); reason != "" {
+					cfg.recordAborted(r, counter, reason)
+				}
+			}()
+
+			// execute next http handler
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+func newRequestsAborted(meter otelmetric.Meter) (otelmetric.Int64Counter, error) {
+	counter, err := meter.Int64Counter(
+		metricNameRequestsAborted,
+		otelmetric.WithDescription(metricDescRequestsAborted),
+		otelmetric.WithUnit(metricUnitRequestsAborted),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s counter: %w", metricNameRequestsAborted, err)
+	}
+	return counter, nil
+}
+
+// recordAborted counts the aborted request using the request attributes & the reason.
+func (cfg BaseConfig) recordAborted(r *http.Request, counter otelmetric.Int64Counter, reason string) {
+	attrs := cfg.requestAttributes(r)
+	attrs = append(attrs[:len(attrs):len(attrs)], AbortedKey.String(reason))
+	counter.Add(r.Context(), 1, otelmetric.WithAttributes(attrs...))
+}
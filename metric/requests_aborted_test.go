@@ -0,0 +1,70 @@
+package metric_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRequestsAborted(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+
+	// the timeout middleware is registered before the metrics middleware
+	// so the deadline is visible to the latter
+	router := chi.NewRouter()
+	router.Use(middleware.Timeout(10 * time.Millisecond))
+	router.Use(metric.NewRequestsAborted(baseCfg))
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	router.Get("/fast", func(w http.ResponseWriter, r *http.Request) {})
+
+	// execute requests, only the fast one is not aborted
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/slow", nil),
+		httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(cancelledCtx),
+		httptest.NewRequest(http.MethodGet, "/fast", nil),
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// read the recorded metrics
+	var rm metricdata.ResourceMetrics
+	err := reader.Collect(context.Background(), &rm)
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	metrics := rm.ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "requests_aborted", metrics[0].Name)
+
+	sum, ok := metrics[0].Data.(metricdata.Sum[int64])
+	require.True(t, ok)
+
+	counts := map[string]int64{}
+	for _, dp := range sum.DataPoints {
+		route, _ := dp.Attributes.Value("http.route")
+		reason, _ := dp.Attributes.Value(metric.AbortedKey)
+		counts[route.AsString()+" "+reason.AsString()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{
+		"/slow deadline_exceeded": 1,
+		"/slow client_cancelled":  1,
+	}, counts)
+}
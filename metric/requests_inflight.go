@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"go.opentelemetry.io/otel/attribute"
 	otelmetric "go.opentelemetry.io/otel/metric"
 )
 
@@ -13,31 +14,50 @@ const (
 	metricDescRequestInFlight = "Measures the number of requests currently being processed by the server."
 )
 
-// [RequestInFlight] is a metrics recorder for recording the number of requests in flight.
+// [RequestInFlight] is a metrics recorder for recording the number of requests in flight. The attributes
+// are evaluated before executing the handler, use [WithChiRoutes] so they contain the route pattern.
 func NewRequestInFlight(cfg BaseConfig) func(next http.Handler) http.Handler {
+	return middlewareOrNoop(NewRequestInFlightE(cfg))
+}
+
+// [NewRequestInFlightE] is like [NewRequestInFlight] but returns the instrument creation error.
+func NewRequestInFlightE(cfg BaseConfig) (func(next http.Handler) http.Handler, error) {
 	// init metric, here we are using counter for capturing request in flight
-	counter, err := cfg.Meter.Int64UpDownCounter(
-		metricNameRequestInFlight,
-		otelmetric.WithDescription(metricDescRequestInFlight),
-		otelmetric.WithUnit(metricUnitRequestInFlight),
-	)
+	counter, err := newRequestInFlight(cfg.Meter)
 	if err != nil {
-		panic(fmt.Sprintf("unable to create %s counter: %v", metricNameRequestInFlight, err))
+		return nil, err
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// define metric attributes
-			attrs := otelmetric.WithAttributes(cfg.AttributesFunc(r)...)
+			// share the request state so the route is only resolved once
+			r = withRequestScope(r)
+
+			// define metric attributes, the same attribute set is used for both
+			// increment & decrement so the counter always goes back to zero
+			attrs := otelmetric.WithAttributeSet(attribute.NewSet(cfg.requestAttributes(r)...))
 
 			// increase the number of requests in flight
 			counter.Add(r.Context(), 1, attrs)
 
+			// decrease the number of requests in flight, this is deferred
+			// so the counter is not leaked when the handler panics
+			defer counter.Add(r.Context(), -1, attrs)
+
 			// execute next http handler
 			next.ServeHTTP(w, r)
-
-			// decrease the number of requests in flight
-			counter.Add(r.Context(), -1, attrs)
 		})
+	}, nil
+}
+
+func newRequestInFlight(meter otelmetric.Meter) (otelmetric.Int64UpDownCounter, error) {
+	counter, err := meter.Int64UpDownCounter(
+		metricNameRequestInFlight,
+		otelmetric.WithDescription(metricDescRequestInFlight),
+		otelmetric.WithUnit(metricUnitRequestInFlight),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s counter: %w", metricNameRequestInFlight, err)
 	}
+	return counter, nil
 }
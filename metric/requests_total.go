@@ -0,0 +1,57 @@
+package metric
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+)
+
+const (
+	metricNameRequestsTotal = "requests_total"
+	metricUnitRequestsTotal = "{request}"
+	metricDescRequestsTotal = "Measures the number of requests handled by the server."
+)
+
+// [NewRequestsTotal] is a metrics recorder for counting the handled requests by route. Unlike the histograms,
+// the counter uses the attributes evaluated before executing the handler, so its series could be pre-registered
+// with zero value using [RegisterRoutes]. Use [WithChiRoutes] so the attributes contain the route pattern.
+func NewRequestsTotal(cfg BaseConfig) func(next http.Handler) http.Handler {
+	return middlewareOrNoop(NewRequestsTotalE(cfg))
+}
+
+// [NewRequestsTotalE] is like [NewRequestsTotal] but returns the instrument creation error.
+func NewRequestsTotalE(cfg BaseConfig) (func(next http.Handler) http.Handler, error) {
+	// init metric, here we are using counter for capturing handled requests
+	counter, err := newRequestsTotal(cfg.Meter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// share the request state so the route is only resolved once
+			r = withRequestScope(r)
+			attrs := otelmetric.WithAttributeSet(attribute.NewSet(cfg.requestAttributes(r)...))
+
+			// count the request
+			defer counter.Add(r.Context(), 1, attrs)
+
+			// execute next http handler
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+func newRequestsTotal(meter otelmetric.Meter) (otelmetric.Int64Counter, error) {
+	counter, err := meter.Int64Counter(
+		metricNameRequestsTotal,
+		otelmetric.WithDescription(metricDescRequestsTotal),
+		otelmetric.WithUnit(metricUnitRequestsTotal),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s counter: %w", metricNameRequestsTotal, err)
+	}
+	return counter, nil
+}
@@ -14,33 +14,51 @@ const (
 )
 
 func NewResponseSizeBytes(cfg BaseConfig) func(next http.Handler) http.Handler {
+	return middlewareOrNoop(NewResponseSizeBytesE(cfg))
+}
+
+// [NewResponseSizeBytesE] is like [NewResponseSizeBytes] but returns the instrument creation error.
+func NewResponseSizeBytesE(cfg BaseConfig) (func(next http.Handler) http.Handler, error) {
 	// init metric, here we are using histogram for capturing response size
-	histogram, err := cfg.Meter.Int64Histogram(
-		metricNameResponseSizeBytes,
-		otelmetric.WithDescription(metricDescResponseSizeBytes),
-		otelmetric.WithUnit(metricUnitResponseSizeBytes),
-	)
+	histogram, err := newResponseSizeBytes(cfg.Meter)
 	if err != nil {
-		panic(fmt.Sprintf("unable to create %s histogram: %v", metricNameResponseSizeBytes, err))
+		return nil, err
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// inject labeler so the handler could add attributes into the record
+			r = withRequestScope(r)
+
 			// get recording response writer
 			rrw := getRRW(w)
 			defer putRRW(rrw)
 
-			// execute next http handler
-			next.ServeHTTP(rrw.writer, r)
-
 			// record the response size
-			histogram.Record(
-				r.Context(),
-				int64(rrw.writtenBytes),
-				otelmetric.WithAttributes(
-					cfg.AttributesFunc(r)...,
-				),
-			)
+			defer func() {
+				histogram.Record(
+					r.Context(),
+					int64(rrw.writtenBytes),
+					otelmetric.WithAttributes(
+						cfg.responseAttributes(r, rrw.info())...,
+					),
+				)
+			}()
+
+			// execute next http handler
+			rrw.serve(next, r)
 		})
+	}, nil
+}
+
+func newResponseSizeBytes(meter otelmetric.Meter) (otelmetric.Int64Histogram, error) {
+	histogram, err := meter.Int64Histogram(
+		metricNameResponseSizeBytes,
+		otelmetric.WithDescription(metricDescResponseSizeBytes),
+		otelmetric.WithUnit(metricUnitResponseSizeBytes),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s histogram: %w", metricNameResponseSizeBytes, err)
 	}
+	return histogram, nil
 }
@@ -2,14 +2,20 @@ package metric_test
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/riandyrn/otelchi/metric"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
@@ -53,3 +59,126 @@ func TestResponseSizeBytes(t *testing.T) {
 	assert.Equal(t, int64(len(responseMsg)), dp.Sum)
 	assert.Equal(t, uint64(1), dp.Count)
 }
+
+func TestResponseSizeBytesAccounting(t *testing.T) {
+	// setup environment
+	content := strings.Repeat("Hello, World!", 1000)
+	filePath := filepath.Join(t.TempDir(), "content.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0o600))
+
+	// prepare test cases
+	testCases := []struct {
+		Name     string
+		Handler  http.HandlerFunc
+		ExpBytes int64
+	}{
+		{
+			Name: "Chunked Response",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < 10; i++ {
+					w.Write([]byte("chunk"))
+					w.(http.Flusher).Flush()
+				}
+			},
+			ExpBytes: int64(len("chunk") * 10),
+		},
+		{
+			Name: "Streamed Response",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(w, strings.NewReader(content))
+			},
+			ExpBytes: int64(len(content)),
+		},
+		{
+			Name: "File Served Response",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				f, err := os.Open(filePath)
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				defer f.Close()
+				http.ServeContent(w, r, "content.txt", time.Time{}, f)
+			},
+			ExpBytes: int64(len(content)),
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+			baseCfg := metric.NewBaseConfig("test-server", metric.WithMeterProvider(provider))
+
+			router := chi.NewRouter()
+			router.Use(metric.NewResponseSizeBytes(baseCfg))
+			router.Get("/test", testCase.Handler)
+
+			// use real server so the response writer implements io.ReaderFrom
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL + "/test")
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpBytes, int64(len(body)))
+
+			// close the server so the handler is guaranteed to be finished
+			ts.Close()
+
+			// read the recorded metrics
+			var rm metricdata.ResourceMetrics
+			err = reader.Collect(context.Background(), &rm)
+			require.NoError(t, err)
+			require.Len(t, rm.ScopeMetrics, 1)
+
+			hist, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+			require.True(t, ok)
+			require.Len(t, hist.DataPoints, 1)
+			assert.Equal(t, testCase.ExpBytes, hist.DataPoints[0].Sum)
+		})
+	}
+}
+
+func TestResponseSizeBytesHijacked(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	infoCh := make(chan metric.ResponseInfo, 1)
+	baseCfg := metric.NewBaseConfig(
+		"test-server",
+		metric.WithMeterProvider(provider),
+		metric.WithResponseAttributesFunc(func(req *http.Request, info metric.ResponseInfo) []attribute.KeyValue {
+			infoCh <- info
+			return nil
+		}),
+	)
+
+	router := chi.NewRouter()
+	router.Use(metric.NewResponseSizeBytes(baseCfg))
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nOK")
+		brw.Flush()
+	})
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/test")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// the server doesn't wait for the hijacked connection, so wait for the recorded response
+	info := <-infoCh
+	assert.True(t, info.Hijacked)
+}
@@ -0,0 +1,57 @@
+package metric
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+)
+
+// RegisterRoutes walks the routes using `chi.Walk` & pre-registers zero-valued `requests_inflight` &
+// `requests_total` series for every method & route pattern pair, so the absence based alerts don't misfire
+// for the routes which haven't received any request yet, e.g alert on `requests_total` instead of the
+// count of the duration histogram. The attributes are built by the configured AttributesFunc using a
+// synthetic plain HTTP request containing the route pattern in its route context.
+//
+// The histograms are not pre-registered since recording a value always counts as an observation, so the
+// zero-valued series could only be created for the counters.
+//
+// It is safe to call it again after mounting more routes, the existing series are left untouched.
+func RegisterRoutes(cfg BaseConfig, routes chi.Routes) error {
+	inflight, err := newRequestInFlight(cfg.Meter)
+	if err != nil {
+		return err
+	}
+	total, err := newRequestsTotal(cfg.Meter)
+	if err != nil {
+		return err
+	}
+
+	return chi.Walk(routes, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		req, err := newRouteRequest(method, route)
+		if err != nil {
+			return err
+		}
+		// adding zero creates the series without changing its value
+		attrs := otelmetric.WithAttributeSet(attribute.NewSet(cfg.requestAttributes(req)...))
+		inflight.Add(req.Context(), 0, attrs)
+		total.Add(req.Context(), 0, attrs)
+		return nil
+	})
+}
+
+// newRouteRequest returns a synthetic request for the route, the route pattern is put inside the route
+// context as if the request was routed by chi. The request path is the route pattern itself which may
+// not match the route (e.g the pattern contains regexp), so the route is remembered in the request
+// state as already resolved.
+func newRouteRequest(method, route string) (*http.Request, error) {
+	rctx := chi.NewRouteContext()
+	rctx.RoutePatterns = []string{route}
+	ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+	ctx, state := requeststate.Ensure(ctx)
+	state.SetRoute(route, "")
+	return http.NewRequestWithContext(ctx, method, route, nil)
+}
@@ -0,0 +1,97 @@
+package metric_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRegisterRoutes(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	router := chi.NewRouter()
+	baseCfg := metric.NewBaseConfig(
+		"test-server",
+		metric.WithMeterProvider(provider),
+		metric.WithSemConvStabilityMode(metric.SemConvStabilityModeStable),
+		metric.WithChiRoutes(router),
+	)
+	router.Use(metric.NewRequestInFlight(baseCfg), metric.NewRequestsTotal(baseCfg))
+	router.Get("/users/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {})
+	router.Post("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	// register the routes, the series should be zero-valued
+	require.NoError(t, metric.RegisterRoutes(baseCfg, router))
+	for _, name := range []string{"requests_inflight", "requests_total"} {
+		assert.Equal(t, map[string]int64{
+			"GET /users/{id:[0-9]+}": 0,
+			"POST /users":            0,
+		}, getRouteSeries(t, reader, name), name)
+	}
+
+	// the actual request should be recorded in the registered series
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/123", nil))
+	assert.Len(t, getRouteSeries(t, reader, "requests_inflight"), 2)
+	assert.Equal(t, map[string]int64{
+		"GET /users/{id:[0-9]+}": 1,
+		"POST /users":            0,
+	}, getRouteSeries(t, reader, "requests_total"))
+
+	// mount more routes & register again, the existing series are untouched
+	router.Mount("/admin", func() http.Handler {
+		r := chi.NewRouter()
+		r.Delete("/cache", func(w http.ResponseWriter, r *http.Request) {})
+		return r
+	}())
+	require.NoError(t, metric.RegisterRoutes(baseCfg, router))
+	require.NoError(t, metric.RegisterRoutes(baseCfg, router))
+	assert.Equal(t, map[string]int64{
+		"GET /users/{id:[0-9]+}": 0,
+		"POST /users":            0,
+		"DELETE /admin/cache":    0,
+	}, getRouteSeries(t, reader, "requests_inflight"))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/cache", nil))
+	assert.Len(t, getRouteSeries(t, reader, "requests_inflight"), 3)
+	assert.Equal(t, map[string]int64{
+		"GET /users/{id:[0-9]+}": 1,
+		"POST /users":            0,
+		"DELETE /admin/cache":    1,
+	}, getRouteSeries(t, reader, "requests_total"))
+}
+
+func getRouteSeries(t *testing.T, reader *sdkmetric.ManualReader, name string) map[string]int64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	var dps []metricdata.DataPoint[int64]
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != name {
+			continue
+		}
+		switch data := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			dps = data.DataPoints
+		}
+	}
+
+	series := map[string]int64{}
+	for _, dp := range dps {
+		method, _ := dp.Attributes.Value(attribute.Key("http.request.method"))
+		route, _ := dp.Attributes.Value(attribute.Key("http.route"))
+		series[method.AsString()+" "+route.AsString()] = dp.Value
+	}
+	return series
+}
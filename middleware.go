@@ -1,22 +1,35 @@
 package otelchi
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/felixge/httpsnoop"
-	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi/internal/bodysize"
+	"github.com/riandyrn/otelchi/internal/chiroute"
+	"github.com/riandyrn/otelchi/internal/forwarded"
+	"github.com/riandyrn/otelchi/internal/httpstatus"
+	"github.com/riandyrn/otelchi/internal/requeststate"
+	"github.com/riandyrn/otelchi/internal/semconvutil"
+	"github.com/riandyrn/otelchi/metric"
 	"github.com/riandyrn/otelchi/version"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 
-	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
-	"go.opentelemetry.io/otel/semconv/v1.20.0/httpconv"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
@@ -32,6 +45,12 @@ func newTracer(tp trace.TracerProvider) trace.Tracer {
 // requests. The serverName parameter should describe the name of the
 // (virtual) server handling the request.
 func Middleware(serverName string, opts ...Option) func(next http.Handler) http.Handler {
+	return newMiddleware(serverName, nil, opts...)
+}
+
+// newMiddleware returns the tracing middleware, when metrics is set the
+// middleware also feeds the metric instruments.
+func newMiddleware(serverName string, metrics *metric.Recorder, opts ...Option) func(next http.Handler) http.Handler {
 	cfg := config{}
 	for _, opt := range opts {
 		opt.apply(&cfg)
@@ -46,12 +65,23 @@ func Middleware(serverName string, opts ...Option) func(next http.Handler) http.
 		cfg.propagators = otel.GetTextMapPropagator()
 	}
 
+	if cfg.statusClassifier == nil {
+		cfg.statusClassifier = DefaultStatusClassifier
+	}
+
+	var resolver *forwarded.Resolver
+	if len(cfg.trustedProxies) > 0 {
+		resolver = forwarded.NewResolver(cfg.trustedProxies)
+	}
+
 	return func(handler http.Handler) http.Handler {
 		return traceware{
 			config:     cfg,
 			serverName: serverName,
 			tracer:     tracer,
 			handler:    handler,
+			semconv:    semconvutil.NewHTTPServer(cfg.semconvMode, resolver),
+			metrics:    metrics,
 		}
 	}
 }
@@ -61,12 +91,31 @@ type traceware struct {
 	serverName string
 	tracer     oteltrace.Tracer
 	handler    http.Handler
+	semconv    semconvutil.HTTPServer
+	metrics    *metric.Recorder
 }
 
 type recordingResponseWriter struct {
-	writer  http.ResponseWriter
-	written bool
-	status  int
+	writer       http.ResponseWriter
+	written      bool
+	status       int
+	writtenBytes int64
+	bodyWritten  bool
+	panicked     bool
+	hijacked     bool
+
+	// headers is the allowlist of response headers to be captured,
+	// headerAttrs holds their values at the time the header is written
+	headers     []capturedHeader
+	headerAttrs []attribute.KeyValue
+
+	// span is the server span, it is nil when the request is not traced,
+	// writeEvents reports whether the write events should be recorded on it
+	span        oteltrace.Span
+	writeEvents bool
+
+	// semconv is used for the status code of informational response events
+	semconv semconvutil.HTTPServer
 }
 
 var rrwPool = &sync.Pool{
@@ -75,54 +124,168 @@ var rrwPool = &sync.Pool{
 	},
 }
 
-func getRRW(writer http.ResponseWriter) *recordingResponseWriter {
+func getRRW(writer http.ResponseWriter, semconv semconvutil.HTTPServer, headers []capturedHeader, span oteltrace.Span, writeEvents bool) *recordingResponseWriter {
 	rrw := rrwPool.Get().(*recordingResponseWriter)
 	rrw.written = false
 	rrw.status = http.StatusOK
+	rrw.writtenBytes = 0
+	rrw.bodyWritten = false
+	rrw.panicked = false
+	rrw.hijacked = false
+	rrw.headers = headers
+	rrw.headerAttrs = nil
+	rrw.span = span
+	rrw.writeEvents = writeEvents
+	rrw.semconv = semconv
 	rrw.writer = httpsnoop.Wrap(writer, httpsnoop.Hooks{
 		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
 			return func(b []byte) (int, error) {
-				if !rrw.written {
-					rrw.written = true
-				}
-				return next(b)
+				rrw.writeStarted(writer.Header())
+				n, err := next(b)
+				rrw.writtenBytes += int64(n)
+				rrw.addWriteEvent(int64(n), err)
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				rrw.writeStarted(writer.Header())
+				n, err := next(src)
+				rrw.writtenBytes += n
+				rrw.addWriteEvent(n, err)
+				return n, err
 			}
 		},
 		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
 			return func(statusCode int) {
+				// informational responses are passed through, they are
+				// followed by the final response header
+				if !rrw.written && httpstatus.IsInformational(statusCode) {
+					next(statusCode)
+					rrw.addInformationalEvent(statusCode)
+					return
+				}
 				if !rrw.written {
 					rrw.written = true
 					rrw.status = statusCode
+					rrw.captureHeaders(writer.Header())
 					// only call next WriteHeader when header is not written yet
 					// this is to prevent superfluous WriteHeader call
 					next(statusCode)
 				}
 			}
 		},
+		Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return func() (net.Conn, *bufio.ReadWriter, error) {
+				conn, brw, err := next()
+				if err == nil {
+					rrw.hijacked = true
+				}
+				return conn, brw, err
+			}
+		},
 	})
 	return rrw
 }
 
+// writeStarted is called before writing the response body, it marks the
+// response as written when the handler doesn't explicitly call WriteHeader.
+func (rrw *recordingResponseWriter) writeStarted(h http.Header) {
+	if !rrw.written {
+		rrw.written = true
+		rrw.captureHeaders(h)
+	}
+	if !rrw.bodyWritten {
+		rrw.bodyWritten = true
+		if rrw.span != nil && rrw.writeEvents {
+			rrw.span.AddEvent("first_byte_written")
+		}
+	}
+}
+
+// addWriteEvent records write event when write events are enabled.
+func (rrw *recordingResponseWriter) addWriteEvent(n int64, err error) {
+	if rrw.span == nil || !rrw.writeEvents {
+		return
+	}
+	attrs := []attribute.KeyValue{WroteBytesKey.Int64(n)}
+	if err != nil && err != io.EOF {
+		attrs = append(attrs, WriteErrorKey.String(err.Error()))
+	}
+	rrw.span.AddEvent("write", oteltrace.WithAttributes(attrs...))
+}
+
+// addInformationalEvent records the informational response (e.g `103 Early
+// Hints`) sent before the final response.
+func (rrw *recordingResponseWriter) addInformationalEvent(statusCode int) {
+	if rrw.span == nil {
+		return
+	}
+	rrw.span.AddEvent("informational_response", oteltrace.WithAttributes(rrw.semconv.StatusCode(statusCode)...))
+}
+
+// serve executes the handler using the recording response writer, it marks
+// the response as panicked when the handler doesn't return normally.
+func (rrw *recordingResponseWriter) serve(handler http.Handler, r *http.Request) {
+	rrw.panicked = true
+	handler.ServeHTTP(rrw.writer, r)
+	rrw.panicked = false
+}
+
+// summary returns the measurements of the request for the metrics, the status
+// code of the aborted request is overridden the same way as the span's one.
+func (rrw *recordingResponseWriter) summary(r *http.Request, body *bodysize.Reader, startTime time.Time, abortedStatusCodes map[string]int) metric.RequestSummary {
+	readBytes, _ := bodysize.Consumed(r, body)
+	summary := metric.RequestSummary{
+		Duration:     time.Since(startTime),
+		Aborted:      requeststate.AbortReason(r.Context()),
+		StatusCode:   rrw.status,
+		ReadBytes:    readBytes,
+		WrittenBytes: rrw.writtenBytes,
+		Panicked:     rrw.panicked,
+		Hijacked:     rrw.hijacked,
+	}
+	if rrw.panicked && !rrw.written {
+		summary.StatusCode = http.StatusInternalServerError
+	}
+	if code := abortedStatusCodes[summary.Aborted]; code != 0 {
+		summary.StatusCode = code
+	}
+	return summary
+}
+
+// captureHeaders records the allowlisted response headers, it is called when
+// the header is written since any later modification is not sent to client.
+func (rrw *recordingResponseWriter) captureHeaders(h http.Header) {
+	rrw.headerAttrs = headerAttributes(h, rrw.headers)
+}
+
 func putRRW(rrw *recordingResponseWriter) {
 	rrw.writer = nil
+	rrw.headers = nil
+	rrw.headerAttrs = nil
+	rrw.span = nil
 	rrwPool.Put(rrw)
 }
 
 // ServeHTTP implements the http.Handler interface. It does the actual
 // tracing of the request.
 func (tw traceware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// capture the start time of the request, it is shared by the span &
+	// the metrics so both of them agree on the request duration, it is also
+	// used for the elapsed time of the aborted request
+	startTime := time.Now()
+
 	// go through all filters if any
 	for _, filter := range tw.filters {
 		// if there is a filter that returns false, we skip tracing
 		// and execute next handler
 		if !filter(r) {
-			tw.handler.ServeHTTP(w, r)
+			tw.serveUntraced(w, r)
 			return
 		}
 	}
 
-	// extract tracing header using propagator
-	ctx := tw.propagators.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
 	// create span, based on specification, we need to set already known attributes
 	// when creating the span, the only thing missing here is HTTP route pattern since
 	// in go-chi/chi route pattern could only be extracted once the request is executed
@@ -131,19 +294,51 @@ func (tw traceware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// https://github.com/go-chi/chi/issues/150#issuecomment-278850733
 	//
 	// if we have access to chi routes, we could extract the route pattern beforehand.
+	//
+	// the unmatched requests (not found or method not allowed) are named
+	// differently so they don't pollute the root route.
+	//
+	// the resolved route is remembered in the request state shared with the
+	// metrics, so the routes are matched once per request.
+	ctx, state := requeststate.Ensure(r.Context())
+	r = r.WithContext(ctx)
+
 	spanName := ""
 	routePattern := ""
-	spanAttributes := httpconv.ServerRequest(tw.serverName, r)
-
-	if tw.chiRoutes != nil {
-		rctx := chi.NewRouteContext()
-		if tw.chiRoutes.Match(rctx, r.Method, r.URL.Path) {
-			routePattern = rctx.RoutePattern()
-			spanName = addPrefixToSpanName(tw.requestMethodInSpanName, r.Method, routePattern)
-			spanAttributes = append(spanAttributes, semconv.HTTPRoute(routePattern))
+	unmatched := ""
+	routeResolved := tw.chiRoutes != nil
+	if routeResolved {
+		routePattern, unmatched = chiroute.Resolve(r, tw.chiRoutes)
+		if unmatched != "" {
+			spanName = tw.unmatchedSpanName(r)
+		} else {
+			spanName = tw.spanName(r, routePattern)
 		}
 	}
 
+	// go through all route filters if any, these are evaluated after the
+	// route matching so they could use the route pattern
+	for _, filter := range tw.routeFilters {
+		if !filter(r, routePattern) {
+			tw.serveUntraced(w, r)
+			return
+		}
+	}
+
+	// extract tracing header using propagator
+	ctx = tw.propagators.Extract(ctx, propagation.HeaderCarrier(r.Header))
+
+	spanAttributes := tw.semconv.RequestTraceAttrs(tw.serverName, r)
+	spanAttributes = append(spanAttributes, headerAttributes(r.Header, tw.requestHeaders)...)
+	if len(routePattern) > 0 {
+		spanAttributes = append(spanAttributes, tw.semconv.Route(routePattern))
+	}
+
+	// add custom attributes derived from the request
+	if tw.attributesFunc != nil {
+		spanAttributes = append(spanAttributes, tw.attributesFunc(r)...)
+	}
+
 	// define span start options
 	spanOpts := []oteltrace.SpanStartOption{
 		oteltrace.WithAttributes(spanAttributes...),
@@ -180,6 +375,10 @@ func (tw traceware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if tw.metrics != nil {
+		spanOpts = append(spanOpts, oteltrace.WithTimestamp(startTime))
+	}
+
 	// start span
 	ctx, span := tracer.Start(ctx, spanName, spanOpts...)
 	defer span.End()
@@ -190,35 +389,244 @@ func (tw traceware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add(tw.traceSampledResponseHeaderKey, strconv.FormatBool(span.SpanContext().IsSampled()))
 	}
 
+	// put traceresponse to response header only when `WithTraceResponse` is used
+	if tw.traceResponse != nil && span.SpanContext().IsValid() {
+		tw.writeTraceResponse(ctx, w.Header(), span.SpanContext())
+	}
+
+	// wrap request body for counting the bytes read by the handler
+	var onRead func(n int, err error)
+	if tw.readEvent {
+		onRead = func(n int, err error) {
+			addReadEvent(span, n, err)
+		}
+	}
+	r, body := bodysize.Wrap(r, onRead)
+
 	// get recording response writer
-	rrw := getRRW(w)
+	rrw := getRRW(w, tw.semconv, tw.responseHeaders, span, tw.writeEvent)
 	defer putRRW(rrw)
 
+	// record metrics when the middleware is created by `NewInstrumentation`,
+	// the request passed to the deferred function is the one with span
+	// context since `r` is reassigned below
+	if tw.metrics != nil {
+		// inject labeler so the handler could add attributes into the metrics
+		ctx = metric.ContextWithLabeler(ctx, metric.LabelerFromContext(ctx))
+
+		startAttrs := tw.metrics.Start(r)
+		defer func() {
+			tw.metrics.End(r, startAttrs, rrw.summary(r, body, startTime, tw.abortedStatusCodes))
+		}()
+	}
+
 	// execute next http handler
 	r = r.WithContext(ctx)
-	tw.handler.ServeHTTP(rrw.writer, r)
 
-	// set span name & http route attribute if route pattern cannot be determined
-	// during span creation
-	if len(routePattern) == 0 {
-		routePattern = chi.RouteContext(r.Context()).RoutePattern()
-		span.SetAttributes(semconv.HTTPRoute(routePattern))
+	if tw.panicRecording {
+		defer func() {
+			if rec := recover(); rec != nil {
+				tw.setRoute(span, r, routeResolved, unmatched)
+
+				// `http.ErrAbortHandler` aborts the response silently,
+				// so it is not recorded as failure
+				if rec == http.ErrAbortHandler {
+					span.End()
+					panic(rec)
+				}
+				recordPanic(span, rec)
+
+				// the response is most likely incomplete, so we mark
+				// it as internal server error
+				span.SetAttributes(tw.semconv.StatusCode(http.StatusInternalServerError)...)
+				tw.setEndAttributes(span, r, http.StatusInternalServerError)
+				span.SetStatus(codes.Error, fmt.Sprint(rec))
+
+				// end the span before re-panic, otherwise the sdk will
+				// record the same panic once again when the span ends
+				span.End()
+
+				// re-panic so upstream recoverer could handle it
+				panic(rec)
+			}
+		}()
+	}
+	rrw.serve(tw.handler, r)
+
+	// set span name & http route attribute, the route pattern may not be
+	// determined during span creation
+	tw.setRoute(span, r, routeResolved, unmatched)
+
+	// set captured response headers, when nothing has been written by the
+	// handler the headers are captured now
+	if len(tw.responseHeaders) > 0 {
+		if !rrw.written {
+			rrw.captureHeaders(w.Header())
+		}
+		span.SetAttributes(rrw.headerAttrs...)
+	}
 
-		spanName = addPrefixToSpanName(tw.requestMethodInSpanName, r.Method, routePattern)
-		span.SetName(spanName)
+	// set request & response body size attributes, they are omitted when
+	// there is no body
+	if size, ok := bodysize.Request(r, body); ok {
+		span.SetAttributes(tw.semconv.RequestBodySize(size)...)
+	}
+	if size, ok := bodysize.Response(w.Header(), rrw.writtenBytes); ok {
+		span.SetAttributes(tw.semconv.ResponseBodySize(size)...)
 	}
 
-	// check if the request is a WebSocket upgrade request
-	if isWebSocketRequest(r) {
-		span.SetStatus(codes.Unset, "WebSocket upgrade request")
+	// record the request aborted by the client or by the deadline, the
+	// status code is overridden when it is configured
+	status := rrw.status
+	if aborted := requeststate.AbortReason(r.Context()); aborted != "" {
+		span.SetAttributes(AbortedKey.String(aborted))
+		span.AddEvent("request_aborted", oteltrace.WithAttributes(
+			AbortedKey.String(aborted),
+			AbortedElapsedKey.Float64(time.Since(startTime).Seconds()),
+		))
+		if code := tw.abortedStatusCodes[aborted]; code != 0 {
+			status = code
+		}
+	}
+
+	// add custom attributes known at the end of the request
+	tw.setEndAttributes(span, r, status)
+
+	// set status code attribute, it is skipped for hijacked connection (e.g
+	// WebSocket) since the status code is written directly into the connection
+	if !rrw.hijacked {
+		span.SetAttributes(tw.semconv.StatusCode(status)...)
+	}
+
+	// set span status, the error recorded by the handler is used as the
+	// description when the response is classified as error
+	code, desc := tw.statusClassifier(r, status)
+	err := state.Error()
+	if err != nil {
+		span.SetAttributes(requeststate.ErrorType(err))
+		if code == codes.Error {
+			desc = err.Error()
+		}
+	}
+	span.SetStatus(code, desc)
+}
+
+// serveUntraced executes the handler without tracing the request, the
+// metrics are still recorded when they are enabled.
+func (tw traceware) serveUntraced(w http.ResponseWriter, r *http.Request) {
+	if tw.metrics == nil {
+		tw.handler.ServeHTTP(w, r)
 		return
 	}
 
-	// set status code attribute
-	span.SetAttributes(semconv.HTTPStatusCode(rrw.status))
+	startTime := time.Now()
+	ctx, _ := requeststate.Ensure(r.Context())
+	r = r.WithContext(metric.ContextWithLabeler(ctx, metric.LabelerFromContext(ctx)))
+	r, body := bodysize.Wrap(r, nil)
+	rrw := getRRW(w, tw.semconv, nil, nil, false)
+	defer putRRW(rrw)
+
+	startAttrs := tw.metrics.Start(r)
+	defer func() {
+		tw.metrics.End(r, startAttrs, rrw.summary(r, body, startTime, tw.abortedStatusCodes))
+	}()
 
-	// set span status
-	span.SetStatus(httpconv.ServerStatus(rrw.status))
+	rrw.serve(tw.handler, r)
+}
+
+// writeTraceResponse writes `traceresponse` header & the headers injected by
+// the configured propagator into the response header.
+func (tw traceware) writeTraceResponse(ctx context.Context, h http.Header, spanCtx oteltrace.SpanContext) {
+	h.Set(TraceResponseHeaderKey, fmt.Sprintf(
+		"00-%s-%s-%s",
+		spanCtx.TraceID(),
+		spanCtx.SpanID(),
+		spanCtx.TraceFlags()&oteltrace.FlagsSampled,
+	))
+	if tw.traceResponse.Propagator != nil {
+		tw.traceResponse.Propagator.Inject(ctx, propagation.HeaderCarrier(h))
+	}
+
+	if !tw.traceResponse.ExposeHeaders {
+		return
+	}
+
+	// collect the names of trace headers that actually written
+	names := []string{TraceResponseHeaderKey}
+	if len(tw.traceIDResponseHeaderKey) > 0 {
+		names = append(names, tw.traceIDResponseHeaderKey, tw.traceSampledResponseHeaderKey)
+	}
+	if tw.traceResponse.Propagator != nil {
+		for _, field := range tw.traceResponse.Propagator.Fields() {
+			if len(h.Values(field)) > 0 {
+				names = append(names, field)
+			}
+		}
+	}
+	h.Add("Access-Control-Expose-Headers", strings.Join(names, ", "))
+}
+
+// setRoute sets span name & http route attribute when the route pattern was
+// not resolved during span creation, or when the request resolved as unmatched
+// is routed by chi after all. The route resolved during span creation is
+// reused, so the routes are not matched again.
+func (tw traceware) setRoute(span oteltrace.Span, r *http.Request, routeResolved bool, unmatched string) {
+	// the unmatched requests don't have http route attribute
+	routePattern, endUnmatched := chiroute.Resolve(r, nil)
+	if endUnmatched != "" {
+		span.SetAttributes(chiroute.Attr(endUnmatched))
+		if !routeResolved {
+			span.SetName(tw.unmatchedSpanName(r))
+		}
+		return
+	}
+	if routeResolved && unmatched == "" {
+		return
+	}
+	span.SetAttributes(tw.semconv.Route(routePattern))
+	span.SetName(tw.spanName(r, routePattern))
+}
+
+// unmatchedSpanName returns the span name for the request which doesn't match
+// any route. Without `WithUnmatchedSpanName`, the span name formatter is
+// called using empty route pattern.
+func (tw traceware) unmatchedSpanName(r *http.Request) string {
+	if tw.unmatchedSpanNameFn != nil {
+		return tw.unmatchedSpanNameFn(r)
+	}
+	if tw.spanNameFormatter != nil {
+		return tw.spanNameFormatter(r, "")
+	}
+	return "HTTP " + r.Method
+}
+
+// setEndAttributes adds custom attributes at the end of the request.
+func (tw traceware) setEndAttributes(span oteltrace.Span, r *http.Request, statusCode int) {
+	if tw.endAttributesFunc != nil {
+		span.SetAttributes(tw.endAttributesFunc(r, statusCode)...)
+	}
+}
+
+// spanName returns the span name for the given request & route pattern.
+func (tw traceware) spanName(r *http.Request, routePattern string) string {
+	if tw.spanNameFormatter != nil {
+		return tw.spanNameFormatter(r, routePattern)
+	}
+	return addPrefixToSpanName(tw.requestMethodInSpanName, r.Method, routePattern)
+}
+
+// recordPanic adds exception event containing the panic value & the stack
+// trace of the panicking goroutine.
+func recordPanic(span oteltrace.Span, rec interface{}) {
+	span.AddEvent(
+		semconv.ExceptionEventName,
+		oteltrace.WithAttributes(
+			semconv.ExceptionType(fmt.Sprintf("%T", rec)),
+			semconv.ExceptionMessage(fmt.Sprint(rec)),
+			semconv.ExceptionStacktrace(string(debug.Stack())),
+			semconv.ExceptionEscaped(true),
+		),
+	)
 }
 
 func addPrefixToSpanName(shouldAdd bool, prefix, spanName string) string {
@@ -233,17 +641,3 @@ func addPrefixToSpanName(shouldAdd bool, prefix, spanName string) string {
 	}
 	return spanName
 }
-
-// isWebSocketRequest checks if an HTTP request is a WebSocket upgrade request
-// Fix: https://github.com/riandyrn/otelchi/issues/66
-func isWebSocketRequest(r *http.Request) bool {
-	// Check if the Connection header contains "Upgrade"
-	connectionHeader := r.Header.Get("Connection")
-	if !strings.Contains(strings.ToLower(connectionHeader), "upgrade") {
-		return false
-	}
-
-	// Check if the Upgrade header is "websocket"
-	upgradeHeader := r.Header.Get("Upgrade")
-	return strings.ToLower(upgradeHeader) == "websocket"
-}
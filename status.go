@@ -0,0 +1,81 @@
+package otelchi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/riandyrn/otelchi/internal/semconvutil"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// DefaultStatusClassifier is the classifier used when `WithStatusClassifier`
+// is not set. WebSocket upgrade requests are never errors, the other requests
+// are classified by `ServerStatusClassifier`.
+var DefaultStatusClassifier = WebSocketStatusClassifier(ServerStatusClassifier)
+
+// ServerStatusClassifier classifies the status code as specified by the
+// semantic conventions, only the 5xx status codes are errors.
+func ServerStatusClassifier(_ *http.Request, status int) (codes.Code, string) {
+	return semconvutil.ServerStatus(status)
+}
+
+// WebSocketStatusClassifier returns a classifier which leaves the span status
+// unset for WebSocket upgrade requests, the other requests are classified by
+// next.
+func WebSocketStatusClassifier(next StatusClassifier) StatusClassifier {
+	return func(r *http.Request, status int) (codes.Code, string) {
+		if isWebSocketRequest(r) {
+			return codes.Unset, "WebSocket upgrade request"
+		}
+		return next(r, status)
+	}
+}
+
+// ErrorStatusClassifier returns a classifier which treats the given status
+// codes as errors, e.g 409 & 422 for internal APIs. The other status codes are
+// classified by next.
+func ErrorStatusClassifier(next StatusClassifier, statuses ...int) StatusClassifier {
+	set := statusSet(statuses)
+	return func(r *http.Request, status int) (codes.Code, string) {
+		if set[status] {
+			return codes.Error, fmt.Sprintf("%d %s", status, http.StatusText(status))
+		}
+		return next(r, status)
+	}
+}
+
+// UnsetStatusClassifier returns a classifier which leaves the span status
+// unset for the given status codes, e.g 499 client cancelled or 503 load
+// shedding responses. The other status codes are classified by next.
+func UnsetStatusClassifier(next StatusClassifier, statuses ...int) StatusClassifier {
+	set := statusSet(statuses)
+	return func(r *http.Request, status int) (codes.Code, string) {
+		if set[status] {
+			return codes.Unset, ""
+		}
+		return next(r, status)
+	}
+}
+
+func statusSet(statuses []int) map[int]bool {
+	set := make(map[int]bool, len(statuses))
+	for _, status := range statuses {
+		set[status] = true
+	}
+	return set
+}
+
+// isWebSocketRequest checks if an HTTP request is a WebSocket upgrade request
+// Fix: https://github.com/riandyrn/otelchi/issues/66
+func isWebSocketRequest(r *http.Request) bool {
+	// Check if the Connection header contains "Upgrade"
+	connectionHeader := r.Header.Get("Connection")
+	if !strings.Contains(strings.ToLower(connectionHeader), "upgrade") {
+		return false
+	}
+
+	// Check if the Upgrade header is "websocket"
+	upgradeHeader := r.Header.Get("Upgrade")
+	return strings.ToLower(upgradeHeader) == "websocket"
+}
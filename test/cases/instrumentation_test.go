@@ -0,0 +1,359 @@
+package otelchi_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi"
+	"github.com/riandyrn/otelchi/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewInstrumentation(t *testing.T) {
+	// prepare the separate middlewares & the combined one
+	separateRouter, separateSR, separateReader := newInstrumentationTestRouter(false)
+	combinedRouter, combinedSR, combinedReader := newInstrumentationTestRouter(true)
+
+	// execute the same requests on both routers
+	for _, router := range []*chi.Mux{separateRouter, combinedRouter} {
+		router.Get("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Hello, World!"))
+		})
+		router.Get("/error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+		router.Get("/health", ok)
+
+		executeRequests(router, []*http.Request{
+			httptest.NewRequest("GET", "/user/123", nil),
+			httptest.NewRequest("GET", "/user/456", nil),
+			httptest.NewRequest("GET", "/error", nil),
+			httptest.NewRequest("GET", "/health", nil),
+		})
+	}
+
+	// ensure the spans are the same, the health check is filtered
+	separateSpans := separateSR.Ended()
+	combinedSpans := combinedSR.Ended()
+	require.Len(t, separateSpans, 3)
+	require.Len(t, combinedSpans, 3)
+	for i := range separateSpans {
+		assert.Equal(t, separateSpans[i].Name(), combinedSpans[i].Name())
+		assert.ElementsMatch(t, separateSpans[i].Attributes(), combinedSpans[i].Attributes())
+	}
+
+	// ensure the metrics are the same, including the filtered request
+	separateMetrics := collectMetrics(t, separateReader)
+	combinedMetrics := collectMetrics(t, combinedReader)
+	require.Len(t, combinedMetrics, 5)
+	assert.Equal(t, len(separateMetrics), len(combinedMetrics))
+	for name, separate := range separateMetrics {
+		combined, ok := combinedMetrics[name]
+		require.True(t, ok, "missing metric %s", name)
+
+		switch separateData := separate.Data.(type) {
+		case metricdata.Histogram[int64]:
+			combinedData := combined.Data.(metricdata.Histogram[int64])
+			require.Len(t, combinedData.DataPoints, len(separateData.DataPoints))
+			for _, separateDP := range separateData.DataPoints {
+				combinedDP, ok := findDataPoint(combinedData.DataPoints, separateDP.Attributes)
+				require.True(t, ok, "missing data point %v", separateDP.Attributes)
+				assert.Equal(t, separateDP.Count, combinedDP.Count)
+				if name == "response_size_bytes" {
+					assert.Equal(t, separateDP.Sum, combinedDP.Sum)
+				}
+			}
+		case metricdata.Sum[int64]:
+			combinedData := combined.Data.(metricdata.Sum[int64])
+			require.Len(t, combinedData.DataPoints, len(separateData.DataPoints))
+			for _, separateDP := range separateData.DataPoints {
+				combinedDP, ok := findDataPoint(combinedData.DataPoints, separateDP.Attributes)
+				require.True(t, ok, "missing data point %v", separateDP.Attributes)
+				assert.Equal(t, separateDP.Value, combinedDP.Value)
+			}
+		}
+	}
+}
+
+func newInstrumentationTestRouter(combined bool) (*chi.Mux, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	spanRecorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	tracerProvider.RegisterSpanProcessor(spanRecorder)
+
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	router := chi.NewRouter()
+	traceOpts := []otelchi.Option{
+		otelchi.WithTracerProvider(tracerProvider),
+		otelchi.WithChiRoutes(router),
+		otelchi.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/health"
+		}),
+	}
+	metricOpts := []metric.Option{metric.WithMeterProvider(meterProvider)}
+
+	if combined {
+		router.Use(otelchi.NewInstrumentation("foobar", traceOpts, metricOpts))
+	} else {
+		baseCfg := metric.NewBaseConfig("foobar", append(metricOpts, metric.WithChiRoutes(router))...)
+		router.Use(
+			otelchi.Middleware("foobar", traceOpts...),
+			metric.NewRequestDurationMillis(baseCfg),
+			metric.NewRequestInFlight(baseCfg),
+			metric.NewResponseSizeBytes(baseCfg),
+			metric.NewRequestBodySize(baseCfg),
+			metric.NewRequestsAborted(baseCfg),
+			metric.NewRequestsTotal(baseCfg),
+		)
+	}
+
+	return router, spanRecorder, reader
+}
+
+func collectMetrics(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Metrics {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	metrics := map[string]metricdata.Metrics{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	return metrics
+}
+
+type dataPoint interface {
+	metricdata.HistogramDataPoint[int64] | metricdata.DataPoint[int64]
+}
+
+func findDataPoint[T dataPoint](dps []T, attrs attribute.Set) (T, bool) {
+	for _, dp := range dps {
+		var dpAttrs attribute.Set
+		switch v := any(dp).(type) {
+		case metricdata.HistogramDataPoint[int64]:
+			dpAttrs = v.Attributes
+		case metricdata.DataPoint[int64]:
+			dpAttrs = v.Attributes
+		}
+		if dpAttrs.Equals(&attrs) {
+			return dp, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+func TestNewInstrumentationLabeler(t *testing.T) {
+	// setup environment
+	router, _, reader := newInstrumentationTestRouter(true)
+	router.Get("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		metric.LabelerFromContext(r.Context()).Add(attribute.String("tenant", "foo"))
+		w.Write([]byte("Hello, World!"))
+	})
+	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		metric.LabelerFromContext(r.Context()).Add(attribute.String("tenant", "bar"))
+	})
+
+	// execute both traced & filtered requests
+	executeRequests(router, []*http.Request{
+		httptest.NewRequest("GET", "/user/123", nil),
+		httptest.NewRequest("GET", "/health", nil),
+	})
+
+	// the labeler attributes should be recorded for both requests
+	metrics := collectMetrics(t, reader)
+	for _, name := range []string{"request_duration_millis", "response_size_bytes"} {
+		hist, ok := metrics[name].Data.(metricdata.Histogram[int64])
+		require.True(t, ok, name)
+		require.Len(t, hist.DataPoints, 2, name)
+
+		tenants := []string{}
+		for _, dp := range hist.DataPoints {
+			tenant, _ := dp.Attributes.Value("tenant")
+			tenants = append(tenants, tenant.AsString())
+		}
+		assert.ElementsMatch(t, []string{"foo", "bar"}, tenants, name)
+	}
+}
+
+func TestNewInstrumentationRecordError(t *testing.T) {
+	// setup environment
+	router, sr, reader := newInstrumentationTestRouter(true)
+	router.Get("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		otelchi.RecordError(r, errors.New("user not found"))
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		otelchi.RecordError(r, io.ErrUnexpectedEOF)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	// execute both traced & filtered requests
+	executeRequests(router, []*http.Request{
+		httptest.NewRequest("GET", "/user/123", nil),
+		httptest.NewRequest("GET", "/health", nil),
+	})
+
+	// the error type should be set on the span
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 1)
+	assert.Contains(t, recordedSpans[0].Attributes(), attribute.String("error.type", "*errors.errorString"))
+
+	// the error type should be set on the metrics of both requests
+	hist, ok := collectMetrics(t, reader)["request_duration_millis"].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 2)
+
+	errorTypes := []string{}
+	for _, dp := range hist.DataPoints {
+		errorType, _ := dp.Attributes.Value("error.type")
+		errorTypes = append(errorTypes, errorType.AsString())
+	}
+	assert.ElementsMatch(t, []string{"*errors.errorString", "*errors.errorString"}, errorTypes)
+}
+
+func TestNewInstrumentationSharedOptions(t *testing.T) {
+	// setup environment, the semconv mode & trusted proxies are only given
+	// to the trace options
+	spanRecorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	tracerProvider.RegisterSpanProcessor(spanRecorder)
+	reader := sdkmetric.NewManualReader()
+
+	router := chi.NewRouter()
+	router.Use(otelchi.NewInstrumentation(
+		"foobar",
+		[]otelchi.Option{
+			otelchi.WithTracerProvider(tracerProvider),
+			otelchi.WithSemConvStabilityMode(otelchi.SemConvStabilityModeStable),
+			otelchi.WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+		},
+		[]metric.Option{
+			metric.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		},
+	))
+	router.Get("/user/{id}", ok)
+
+	// execute the request forwarded by the trusted proxy
+	req := httptest.NewRequest("GET", "/user/123", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	executeRequests(router, []*http.Request{req})
+
+	// the span & the metrics should agree on the scheme
+	recordedSpans := spanRecorder.Ended()
+	require.Len(t, recordedSpans, 1)
+	assert.Contains(t, recordedSpans[0].Attributes(), attribute.String("url.scheme", "https"))
+
+	hist, ok := collectMetrics(t, reader)["request_duration_millis"].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+	scheme, _ := hist.DataPoints[0].Attributes.Value("url.scheme")
+	assert.Equal(t, "https", scheme.AsString())
+	_, hasOldScheme := hist.DataPoints[0].Attributes.Value("http.scheme")
+	assert.False(t, hasOldScheme)
+}
+
+// countingRoutes counts the number of times the routes are matched.
+type countingRoutes struct {
+	*chi.Mux
+	matches int
+}
+
+func (routes *countingRoutes) Match(rctx *chi.Context, method, path string) bool {
+	routes.matches++
+	return routes.Mux.Match(rctx, method, path)
+}
+
+func TestNewInstrumentationResolvesRouteOnce(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name       string
+		Req        *http.Request
+		ExpMatches int
+	}{
+		{
+			Name:       "Matched",
+			Req:        httptest.NewRequest("GET", "/user/123", nil),
+			ExpMatches: 1,
+		},
+		{
+			// the other methods are probed for telling apart method
+			// not allowed from not found
+			Name:       "Not Found",
+			Req:        httptest.NewRequest("GET", "/unknown", nil),
+			ExpMatches: 5,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			router := chi.NewRouter()
+			routes := &countingRoutes{Mux: router}
+			reader := sdkmetric.NewManualReader()
+			router.Use(otelchi.NewInstrumentation(
+				"foobar",
+				[]otelchi.Option{
+					otelchi.WithTracerProvider(sdktrace.NewTracerProvider()),
+					otelchi.WithChiRoutes(routes),
+				},
+				[]metric.Option{
+					metric.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+				},
+			))
+			router.Get("/user/{id}", ok)
+
+			executeRequests(router, []*http.Request{testCase.Req})
+			assert.Equal(t, testCase.ExpMatches, routes.matches)
+		})
+	}
+}
+
+func TestNewInstrumentationAbortedStatusCode(t *testing.T) {
+	// setup environment
+	reader := sdkmetric.NewManualReader()
+	router := chi.NewRouter()
+	router.Use(otelchi.NewInstrumentation(
+		"foobar",
+		[]otelchi.Option{
+			otelchi.WithTracerProvider(sdktrace.NewTracerProvider()),
+			otelchi.WithAbortedStatusCodes(499, 0),
+		},
+		[]metric.Option{
+			metric.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		},
+	))
+	router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// execute the request cancelled by the client
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	executeRequests(router, []*http.Request{
+		httptest.NewRequest("GET", "/test", nil).WithContext(ctx),
+	})
+
+	// the metrics should record the overridden status code as the span does
+	hist, ok := collectMetrics(t, reader)["request_duration_millis"].Data.(metricdata.Histogram[int64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+	status, _ := hist.DataPoints[0].Attributes.Value("http.status_code")
+	assert.Equal(t, int64(499), status.AsInt64())
+}
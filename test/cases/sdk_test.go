@@ -2,15 +2,25 @@ package otelchi_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/netip"
+	"net/textproto"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/websocket"
 	"github.com/riandyrn/otelchi"
+	"github.com/riandyrn/otelchi/filters"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/otel/attribute"
@@ -841,3 +851,1150 @@ func TestSDKIntegrationWithWebsocket(t *testing.T) {
 		},
 	})
 }
+
+func TestSDKIntegrationWithSemConvStabilityMode(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name       string
+		Mode       otelchi.SemConvStabilityMode
+		Env        string
+		ExpAttrs   []attribute.KeyValue
+		UnexpAttrs []attribute.Key
+	}{
+		{
+			Name: "Old Mode",
+			Mode: otelchi.SemConvStabilityModeOld,
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.method", "GET"),
+				attribute.Int("http.status_code", http.StatusOK),
+				attribute.String("net.host.name", "foobar"),
+			},
+			UnexpAttrs: []attribute.Key{"http.request.method", "http.response.status_code", "server.address", "url.path"},
+		},
+		{
+			Name: "Stable Mode",
+			Mode: otelchi.SemConvStabilityModeStable,
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.request.method", "GET"),
+				attribute.Int("http.response.status_code", http.StatusOK),
+				attribute.String("server.address", "foobar"),
+				attribute.String("url.path", "/user/123"),
+				attribute.String("url.scheme", "http"),
+				attribute.String("http.route", "/user/{id:[0-9]+}"),
+			},
+			UnexpAttrs: []attribute.Key{"http.method", "http.status_code", "net.host.name"},
+		},
+		{
+			Name: "Dup Mode",
+			Mode: otelchi.SemConvStabilityModeDup,
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.method", "GET"),
+				attribute.Int("http.status_code", http.StatusOK),
+				attribute.String("http.request.method", "GET"),
+				attribute.Int("http.response.status_code", http.StatusOK),
+				attribute.String("http.route", "/user/{id:[0-9]+}"),
+			},
+		},
+		{
+			Name: "Dup Mode From Env",
+			Mode: otelchi.SemConvStabilityModeDefault,
+			Env:  "http/dup",
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.method", "GET"),
+				attribute.String("http.request.method", "GET"),
+			},
+		},
+		{
+			Name: "Stable Mode From Env",
+			Mode: otelchi.SemConvStabilityModeDefault,
+			Env:  "messaging, http",
+			ExpAttrs: []attribute.KeyValue{
+				attribute.String("http.request.method", "GET"),
+			},
+			UnexpAttrs: []attribute.Key{"http.method"},
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			t.Setenv("OTEL_SEMCONV_STABILITY_OPT_IN", testCase.Env)
+
+			// prepare router and span recorder
+			router, sr := newSDKTestRouter("foobar", false, otelchi.WithSemConvStabilityMode(testCase.Mode))
+			router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+			// execute requests
+			executeRequests(router, []*http.Request{
+				httptest.NewRequest("GET", "/user/123", nil),
+			})
+
+			// check recorded spans
+			recordedSpans := sr.Ended()
+			require.Len(t, recordedSpans, 1)
+			assertSpan(t, recordedSpans[0], "/user/{id:[0-9]+}", trace.SpanKindServer, codes.Unset, testCase.ExpAttrs...)
+
+			for _, attr := range recordedSpans[0].Attributes() {
+				assert.NotContains(t, testCase.UnexpAttrs, attr.Key)
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithPanicRecording(t *testing.T) {
+	// prepare span recorder
+	spanRecorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	tracerProvider.RegisterSpanProcessor(spanRecorder)
+
+	// put recoverer before otelchi so it could handle the re-panic
+	router := chi.NewRouter()
+	router.Use(
+		middleware.Recoverer,
+		otelchi.Middleware(
+			"foobar",
+			otelchi.WithTracerProvider(tracerProvider),
+			otelchi.WithPanicRecording(true),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	// execute request
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/user/123", nil))
+
+	// ensure the panic is handled by the recoverer
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// ensure span values
+	recordedSpans := spanRecorder.Ended()
+	require.Len(t, recordedSpans, 1)
+	assertSpan(
+		t,
+		recordedSpans[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		codes.Error,
+		getSemanticAttributes("foobar", http.StatusInternalServerError, "GET", "/user/{id:[0-9]+}")...,
+	)
+	assert.Equal(t, "something went wrong", recordedSpans[0].Status().Description)
+
+	// ensure exception event is recorded
+	events := recordedSpans[0].Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "exception", events[0].Name)
+
+	eventAttrs := attribute.NewSet(events[0].Attributes...)
+	excType, _ := eventAttrs.Value("exception.type")
+	assert.Equal(t, "string", excType.AsString())
+	excMsg, _ := eventAttrs.Value("exception.message")
+	assert.Equal(t, "something went wrong", excMsg.AsString())
+	excStack, _ := eventAttrs.Value("exception.stacktrace")
+	assert.Contains(t, excStack.AsString(), "panic")
+}
+
+func TestSDKIntegrationWithPanicRecordingAbortHandler(t *testing.T) {
+	// prepare span recorder
+	spanRecorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	tracerProvider.RegisterSpanProcessor(spanRecorder)
+
+	// put recoverer before otelchi, it re-panics `http.ErrAbortHandler` so
+	// the http server could abort the response
+	router := chi.NewRouter()
+	router.Use(
+		middleware.Recoverer,
+		otelchi.Middleware(
+			"foobar",
+			otelchi.WithTracerProvider(tracerProvider),
+			otelchi.WithPanicRecording(true),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	// execute request
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/user/123", nil))
+	})
+
+	// ensure the aborted response is not recorded as failure
+	recordedSpans := spanRecorder.Ended()
+	require.Len(t, recordedSpans, 1)
+	assert.Equal(t, "/user/{id:[0-9]+}", recordedSpans[0].Name())
+	assert.Equal(t, codes.Unset, recordedSpans[0].Status().Code)
+	assert.Empty(t, recordedSpans[0].Events())
+	for _, attr := range recordedSpans[0].Attributes() {
+		assert.NotEqual(t, attribute.Key("http.status_code"), attr.Key)
+	}
+}
+
+func TestSDKIntegrationWithoutPanicRecording(t *testing.T) {
+	// prepare router and span recorder
+	router, sr := newSDKTestRouter("foobar", false)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	// ensure the panic is not swallowed
+	assert.PanicsWithValue(t, "something went wrong", func() {
+		executeRequests(router, []*http.Request{httptest.NewRequest("GET", "/user/123", nil)})
+	})
+
+	// ensure the span is not marked as error
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 1)
+	assert.Equal(t, codes.Unset, recordedSpans[0].Status().Code)
+}
+
+func TestSDKIntegrationWithHeaders(t *testing.T) {
+	// prepare router and span recorder
+	router, sr := newSDKTestRouter(
+		"foobar",
+		true,
+		otelchi.WithRequestHeaders("x-request-id", "Authorization", "X-Missing"),
+		otelchi.WithResponseHeaders("Content-Type", "set-cookie", "X-Late"),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusOK)
+
+		// this header is not sent to the client, so it must not be captured
+		w.Header().Set("X-Late", "late")
+	})
+	router.HandleFunc("/book/{title}", func(w http.ResponseWriter, r *http.Request) {
+		// nothing is written, the headers are captured after the handler returns
+		w.Header().Set("X-Late", "not late")
+	})
+
+	// execute requests
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Add("X-Request-Id", "req-1")
+	r0.Header.Add("X-Request-Id", "req-2")
+	r0.Header.Set("Authorization", "Bearer secret")
+	executeRequests(router, []*http.Request{r0, httptest.NewRequest("GET", "/book/foo", nil)})
+
+	// check recorded spans
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 2)
+
+	assertSpan(
+		t,
+		recordedSpans[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.StringSlice("http.request.header.x-request-id", []string{"req-1", "req-2"}),
+		attribute.StringSlice("http.request.header.authorization", []string{otelchi.RedactedHeaderValue}),
+		attribute.StringSlice("http.response.header.content-type", []string{"application/json"}),
+		attribute.StringSlice("http.response.header.set-cookie", []string{otelchi.RedactedHeaderValue}),
+	)
+	for _, attr := range recordedSpans[0].Attributes() {
+		assert.NotEqual(t, attribute.Key("http.request.header.x-missing"), attr.Key)
+		assert.NotEqual(t, attribute.Key("http.response.header.x-late"), attr.Key)
+	}
+
+	assertSpan(
+		t,
+		recordedSpans[1],
+		"/book/{title}",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.StringSlice("http.response.header.x-late", []string{"not late"}),
+	)
+}
+
+func TestSDKIntegrationWithSpanNameFormatter(t *testing.T) {
+	formatter := func(r *http.Request, routePattern string) string {
+		if routePattern == "" {
+			return "HTTP " + r.Method
+		}
+		return "foobar " + r.Method + " " + strings.TrimSuffix(routePattern, "/*")
+	}
+
+	for _, withChiRoutes := range []bool{true, false} {
+		t.Run(fmt.Sprintf("WithChiRoutes %v", withChiRoutes), func(t *testing.T) {
+			// prepare router and span recorder
+			router, sr := newSDKTestRouter(
+				"foobar",
+				withChiRoutes,
+				otelchi.WithSpanNameFormatter(formatter),
+				// ignored since span name formatter is set
+				otelchi.WithRequestMethodInSpanName(true),
+			)
+
+			// define routes
+			var startSpanName string
+			router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+				startSpanName = trace.SpanFromContext(r.Context()).(sdktrace.ReadOnlySpan).Name()
+				w.WriteHeader(http.StatusOK)
+			})
+			router.Mount("/static", http.HandlerFunc(ok))
+
+			// execute requests
+			executeRequests(router, []*http.Request{
+				httptest.NewRequest("GET", "/user/123", nil),
+				httptest.NewRequest("GET", "/static/app.js", nil),
+				httptest.NewRequest("POST", "/unknown", nil),
+			})
+
+			// ensure the formatter is used during span creation when the
+			// route could be resolved beforehand
+			if withChiRoutes {
+				assert.Equal(t, "foobar GET /user/{id:[0-9]+}", startSpanName)
+			} else {
+				assert.Equal(t, "", startSpanName)
+			}
+
+			// check recorded spans
+			recordedSpans := sr.Ended()
+			require.Len(t, recordedSpans, 3)
+			assert.Equal(t, "foobar GET /user/{id:[0-9]+}", recordedSpans[0].Name())
+			assert.Equal(t, "foobar GET /static", recordedSpans[1].Name())
+			assert.Equal(t, "HTTP POST", recordedSpans[2].Name())
+		})
+	}
+}
+
+func TestSDKIntegrationWithAttributesFunc(t *testing.T) {
+	// prepare sampler that only samples requests from premium tenant, this
+	// is to ensure the custom attributes are visible to the sampler
+	sampler := &attributeSampler{
+		Key:   "tenant",
+		Value: attribute.StringValue("premium"),
+	}
+	spanRecorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler))
+	tracerProvider.RegisterSpanProcessor(spanRecorder)
+
+	router := chi.NewRouter()
+	router.Use(otelchi.Middleware(
+		"foobar",
+		otelchi.WithTracerProvider(tracerProvider),
+		otelchi.WithAttributesFunc(func(r *http.Request) []attribute.KeyValue {
+			return []attribute.KeyValue{attribute.String("tenant", r.Header.Get("X-Tenant"))}
+		}),
+		otelchi.WithEndAttributesFunc(func(r *http.Request, statusCode int) []attribute.KeyValue {
+			return []attribute.KeyValue{attribute.Bool("is_created", statusCode == http.StatusCreated)}
+		}),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// execute requests
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("X-Tenant", "premium")
+	r1 := httptest.NewRequest("GET", "/user/456", nil)
+	r1.Header.Set("X-Tenant", "free")
+	executeRequests(router, []*http.Request{r0, r1})
+
+	// ensure only the premium tenant request is sampled
+	recordedSpans := spanRecorder.Ended()
+	require.Len(t, recordedSpans, 1)
+	assertSpan(
+		t,
+		recordedSpans[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.String("tenant", "premium"),
+		attribute.Bool("is_created", true),
+	)
+}
+
+// attributeSampler samples spans which have the given attribute during
+// span creation.
+type attributeSampler struct {
+	Key   attribute.Key
+	Value attribute.Value
+}
+
+func (s *attributeSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	decision := sdktrace.Drop
+	for _, attr := range p.Attributes {
+		if attr.Key == s.Key && attr.Value == s.Value {
+			decision = sdktrace.RecordAndSample
+		}
+	}
+	return sdktrace.SamplingResult{Decision: decision}
+}
+
+func (s *attributeSampler) Description() string {
+	return "attributeSampler"
+}
+
+func TestSDKIntegrationWithTraceResponse(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name             string
+		Config           otelchi.TraceResponseConfig
+		ExtraOpts        []otelchi.Option
+		ExpTraceparent   bool
+		ExpExposeHeaders string
+	}{
+		{
+			Name:   "Trace Response Only",
+			Config: otelchi.TraceResponseConfig{},
+		},
+		{
+			Name: "With Propagator",
+			Config: otelchi.TraceResponseConfig{
+				Propagator: propagation.TraceContext{},
+			},
+			ExpTraceparent: true,
+		},
+		{
+			Name: "With Propagator & Expose Headers",
+			Config: otelchi.TraceResponseConfig{
+				Propagator:    propagation.TraceContext{},
+				ExposeHeaders: true,
+			},
+			ExpTraceparent:   true,
+			ExpExposeHeaders: "traceresponse, traceparent",
+		},
+		{
+			Name: "With Trace Response Headers & Expose Headers",
+			Config: otelchi.TraceResponseConfig{
+				ExposeHeaders: true,
+			},
+			ExtraOpts:        []otelchi.Option{otelchi.WithTraceResponseHeaders(otelchi.TraceHeaderConfig{})},
+			ExpExposeHeaders: "traceresponse, X-Trace-Id, X-Trace-Sampled",
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// prepare router and span recorder
+			opts := append([]otelchi.Option{otelchi.WithTraceResponse(testCase.Config)}, testCase.ExtraOpts...)
+			router, sr := newSDKTestRouter("foobar", true, opts...)
+			router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+			// execute request
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/user/123", nil))
+
+			// ensure the header contains the server span context
+			recordedSpans := sr.Ended()
+			require.Len(t, recordedSpans, 1)
+			spanCtx := recordedSpans[0].SpanContext()
+
+			expTraceResponse := fmt.Sprintf("00-%s-%s-01", spanCtx.TraceID(), spanCtx.SpanID())
+			assert.Equal(t, expTraceResponse, w.Header().Get(otelchi.TraceResponseHeaderKey))
+			if testCase.ExpTraceparent {
+				assert.Equal(t, expTraceResponse, w.Header().Get("traceparent"))
+			} else {
+				assert.Empty(t, w.Header().Get("traceparent"))
+			}
+			assert.Equal(t, testCase.ExpExposeHeaders, w.Header().Get("Access-Control-Expose-Headers"))
+		})
+	}
+}
+
+func TestSDKIntegrationBodySize(t *testing.T) {
+	// prepare router and span recorder
+	router, sr := newSDKTestRouter("foobar", true, otelchi.WithSemConvStabilityMode(otelchi.SemConvStabilityModeDup))
+	router.Post("/read", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Write(body)
+		w.Write(body)
+	})
+	router.Post("/unread", func(w http.ResponseWriter, r *http.Request) {
+		// io.Copy uses ReadFrom of the response writer
+		io.Copy(w, strings.NewReader("response body"))
+	})
+	router.Get("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Post("/partial", func(w http.ResponseWriter, r *http.Request) {
+		// the handler stops reading once the body is too large
+		_, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 3))
+		require.Error(t, err)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	})
+
+	// execute requests, the body of the first request is sent with
+	// unknown content length
+	r0 := httptest.NewRequest("POST", "/read", strings.NewReader("hello"))
+	r0.ContentLength = -1
+	r1 := httptest.NewRequest("POST", "/unread", strings.NewReader("hello world"))
+	r2 := httptest.NewRequest("GET", "/empty", nil)
+	r3 := httptest.NewRequest("POST", "/partial", strings.NewReader("hello world"))
+	executeRequests(router, []*http.Request{r0, r1, r2, r3})
+
+	// check recorded spans
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 4)
+	assertSpan(
+		t,
+		recordedSpans[0],
+		"/read",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.Int("http.request.body.size", 5),
+		attribute.Int("http.response.body.size", 10),
+		attribute.Int("http.request_content_length", 5),
+		attribute.Int("http.response_content_length", 10),
+	)
+	assertSpan(
+		t,
+		recordedSpans[1],
+		"/unread",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.Int("http.request.body.size", 11),
+		attribute.Int("http.response.body.size", 13),
+	)
+
+	// the body sizes are omitted when there is neither request nor
+	// response body
+	for _, attr := range recordedSpans[2].Attributes() {
+		assert.NotContains(t, []attribute.Key{
+			"http.request.body.size",
+			"http.response.body.size",
+			"http.request_content_length",
+			"http.response_content_length",
+		}, attr.Key)
+	}
+
+	// the content length is used when the handler stops reading early
+	assert.Contains(t, recordedSpans[3].Attributes(), attribute.Int("http.request.body.size", 11))
+	assert.Contains(t, recordedSpans[3].Attributes(), attribute.Int("http.request_content_length", 11))
+}
+
+func TestSDKIntegrationBodySizeReadFrom(t *testing.T) {
+	// prepare router and span recorder
+	router, sr := newSDKTestRouter("foobar", true, otelchi.WithSemConvStabilityMode(otelchi.SemConvStabilityModeStable))
+	router.Get("/file", func(w http.ResponseWriter, r *http.Request) {
+		// the response writer of http server implements io.ReaderFrom
+		_, isReaderFrom := w.(io.ReaderFrom)
+		require.True(t, isReaderFrom)
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader("response body"))
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/file")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// check recorded spans
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 1)
+	assertSpan(
+		t,
+		recordedSpans[0],
+		"/file",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.Int("http.response.body.size", 13),
+	)
+
+	// the request body size is omitted since there is no request body
+	for _, attr := range recordedSpans[0].Attributes() {
+		assert.NotEqual(t, attribute.Key("http.request.body.size"), attr.Key)
+	}
+}
+
+func TestSDKIntegrationWithMessageEvents(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name          string
+		Events        []otelchi.Event
+		ExpEvents     []string
+		ExpReadBytes  int64
+		ExpWroteBytes int64
+	}{
+		{
+			Name:      "No Events",
+			ExpEvents: []string{},
+		},
+		{
+			Name:         "Read Events",
+			Events:       []otelchi.Event{otelchi.ReadEvents},
+			ExpEvents:    []string{"read"},
+			ExpReadBytes: 5,
+		},
+		{
+			Name:          "Write Events",
+			Events:        []otelchi.Event{otelchi.WriteEvents},
+			ExpEvents:     []string{"first_byte_written", "write", "write"},
+			ExpWroteBytes: 5,
+		},
+		{
+			Name:          "Read & Write Events",
+			Events:        []otelchi.Event{otelchi.ReadEvents, otelchi.WriteEvents},
+			ExpEvents:     []string{"read", "first_byte_written", "write", "write"},
+			ExpReadBytes:  5,
+			ExpWroteBytes: 5,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// prepare router and span recorder
+			router, sr := newSDKTestRouter("foobar", true, otelchi.WithMessageEvents(testCase.Events...))
+			router.Post("/echo", func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+
+				w.WriteHeader(http.StatusOK)
+				w.Write(body[:2])
+				w.Write(body[2:])
+			})
+
+			// execute request
+			executeRequests(router, []*http.Request{
+				httptest.NewRequest("POST", "/echo", strings.NewReader("hello")),
+			})
+
+			// check recorded events
+			recordedSpans := sr.Ended()
+			require.Len(t, recordedSpans, 1)
+
+			events := recordedSpans[0].Events()
+			eventNames := make([]string, 0, len(events))
+			for _, event := range events {
+				eventNames = append(eventNames, event.Name)
+			}
+			require.Equal(t, testCase.ExpEvents, eventNames)
+
+			// ensure the number of bytes is recorded
+			var readBytes, wroteBytes int64
+			for _, event := range events {
+				for _, attr := range event.Attributes {
+					switch attr.Key {
+					case otelchi.ReadBytesKey:
+						readBytes += attr.Value.AsInt64()
+					case otelchi.WroteBytesKey:
+						wroteBytes += attr.Value.AsInt64()
+					}
+				}
+			}
+			assert.Equal(t, testCase.ExpReadBytes, readBytes)
+			assert.Equal(t, testCase.ExpWroteBytes, wroteBytes)
+		})
+	}
+}
+
+func TestSDKIntegrationWithTrustedProxies(t *testing.T) {
+	// prepare router and span recorder
+	router, sr := newSDKTestRouter(
+		"",
+		true,
+		otelchi.WithSemConvStabilityMode(otelchi.SemConvStabilityModeDup),
+		otelchi.WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	// execute requests, the first one comes from trusted proxy while the
+	// second one comes directly from the client
+	r0 := httptest.NewRequest("GET", "http://internal.svc/user/123", nil)
+	r0.RemoteAddr = "10.0.0.1:1234"
+	r0.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r0.Header.Set("X-Forwarded-Proto", "https")
+	r0.Header.Set("X-Forwarded-Host", "api.example.com")
+
+	r1 := httptest.NewRequest("GET", "http://internal.svc/user/123", nil)
+	r1.RemoteAddr = "192.0.2.1:1234"
+	r1.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r1.Header.Set("X-Forwarded-Proto", "https")
+	executeRequests(router, []*http.Request{r0, r1})
+
+	// check recorded spans
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 2)
+	assertSpan(
+		t,
+		recordedSpans[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.String("client.address", "198.51.100.1"),
+		attribute.String("url.scheme", "https"),
+		attribute.String("server.address", "api.example.com"),
+		attribute.String("http.client_ip", "198.51.100.1"),
+		attribute.String("http.scheme", "https"),
+		attribute.String("net.host.name", "api.example.com"),
+		attribute.String("network.peer.address", "10.0.0.1"),
+	)
+	assertSpan(
+		t,
+		recordedSpans[1],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.String("client.address", "192.0.2.1"),
+		attribute.String("url.scheme", "http"),
+		attribute.String("server.address", "internal.svc"),
+		attribute.String("http.client_ip", "192.0.2.1"),
+		attribute.String("http.scheme", "http"),
+	)
+}
+
+func TestSDKIntegrationWithRouteFilter(t *testing.T) {
+	// prepare router and span recorder
+	var filteredPatterns []string
+	router, sr := newSDKTestRouter(
+		"foobar",
+		true,
+		otelchi.WithRouteFilter(func(r *http.Request, routePattern string) bool {
+			filteredPatterns = append(filteredPatterns, routePattern)
+			return true
+		}),
+		otelchi.WithRouteFilter(filters.Not(filters.Route("/users/*/avatar"))),
+	)
+	router.HandleFunc("/users/{id}", ok)
+	router.HandleFunc("/users/{id}/avatar", ok)
+
+	// execute requests
+	executeRequests(router, []*http.Request{
+		httptest.NewRequest("GET", "/users/123", nil),
+		httptest.NewRequest("GET", "/users/123/avatar", nil),
+		httptest.NewRequest("GET", "/unknown", nil),
+	})
+
+	// ensure route filters receive the matched route pattern
+	assert.Equal(t, []string{"/users/{id}", "/users/{id}/avatar", ""}, filteredPatterns)
+
+	// ensure the avatar route is not traced
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 2)
+	assert.Equal(t, "/users/{id}", recordedSpans[0].Name())
+}
+
+func TestSDKIntegrationWithStatusClassifier(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name       string
+		Classifier otelchi.StatusClassifier
+		StatusCode int
+		ExpStatus  codes.Code
+		ExpDesc    string
+	}{
+		{
+			Name:       "Default Client Error",
+			StatusCode: http.StatusConflict,
+			ExpStatus:  codes.Unset,
+		},
+		{
+			Name:       "Default Server Error",
+			StatusCode: http.StatusServiceUnavailable,
+			ExpStatus:  codes.Error,
+		},
+		{
+			Name:       "Error Classifier",
+			Classifier: otelchi.ErrorStatusClassifier(otelchi.DefaultStatusClassifier, http.StatusConflict, http.StatusUnprocessableEntity),
+			StatusCode: http.StatusUnprocessableEntity,
+			ExpStatus:  codes.Error,
+			ExpDesc:    "422 Unprocessable Entity",
+		},
+		{
+			Name:       "Unset Classifier",
+			Classifier: otelchi.UnsetStatusClassifier(otelchi.DefaultStatusClassifier, http.StatusServiceUnavailable),
+			StatusCode: http.StatusServiceUnavailable,
+			ExpStatus:  codes.Unset,
+		},
+		{
+			Name:       "Unset Classifier Other Status",
+			Classifier: otelchi.UnsetStatusClassifier(otelchi.DefaultStatusClassifier, http.StatusServiceUnavailable),
+			StatusCode: http.StatusInternalServerError,
+			ExpStatus:  codes.Error,
+		},
+		{
+			Name: "Custom Classifier",
+			Classifier: func(r *http.Request, status int) (codes.Code, string) {
+				if status == 499 {
+					return codes.Error, "client closed request"
+				}
+				return otelchi.ServerStatusClassifier(r, status)
+			},
+			StatusCode: 499,
+			ExpStatus:  codes.Error,
+			ExpDesc:    "client closed request",
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var opts []otelchi.Option
+			if testCase.Classifier != nil {
+				opts = append(opts, otelchi.WithStatusClassifier(testCase.Classifier))
+			}
+			router, sr := newSDKTestRouter("foobar", false, opts...)
+			router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.StatusCode)
+			})
+
+			executeRequests(router, []*http.Request{httptest.NewRequest("GET", "/test", nil)})
+
+			recordedSpans := sr.Ended()
+			require.Len(t, recordedSpans, 1)
+			assert.Equal(t, testCase.ExpStatus, recordedSpans[0].Status().Code)
+			assert.Equal(t, testCase.ExpDesc, recordedSpans[0].Status().Description)
+			assert.Contains(t, recordedSpans[0].Attributes(), attribute.Int("http.status_code", testCase.StatusCode))
+		})
+	}
+}
+
+func TestSDKIntegrationRecordError(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name       string
+		StatusCode int
+		Err        error
+		ExpStatus  codes.Code
+		ExpDesc    string
+	}{
+		{
+			Name:       "Server Error",
+			StatusCode: http.StatusInternalServerError,
+			Err:        errors.New("database is down"),
+			ExpStatus:  codes.Error,
+			ExpDesc:    "database is down",
+		},
+		{
+			Name:       "Client Error",
+			StatusCode: http.StatusNotFound,
+			Err:        fs.ErrNotExist,
+			ExpStatus:  codes.Unset,
+		},
+		{
+			Name:       "Server Error Without Recorded Error",
+			StatusCode: http.StatusInternalServerError,
+			ExpStatus:  codes.Error,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			router, sr := newSDKTestRouter("foobar", false)
+			router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+				otelchi.RecordError(r, testCase.Err)
+				w.WriteHeader(testCase.StatusCode)
+			})
+
+			executeRequests(router, []*http.Request{httptest.NewRequest("GET", "/test", nil)})
+
+			recordedSpans := sr.Ended()
+			require.Len(t, recordedSpans, 1)
+			span := recordedSpans[0]
+			assert.Equal(t, testCase.ExpStatus, span.Status().Code)
+			assert.Equal(t, testCase.ExpDesc, span.Status().Description)
+
+			if testCase.Err == nil {
+				assert.Empty(t, span.Events())
+				for _, attr := range span.Attributes() {
+					assert.NotEqual(t, attribute.Key("error.type"), attr.Key)
+				}
+				return
+			}
+
+			assert.Contains(t, span.Attributes(), attribute.String("error.type", fmt.Sprintf("%T", testCase.Err)))
+			require.Len(t, span.Events(), 1)
+			assert.Equal(t, "exception", span.Events()[0].Name)
+			assert.Contains(t, span.Events()[0].Attributes, attribute.String("exception.message", testCase.Err.Error()))
+		})
+	}
+}
+
+func TestSDKIntegrationUnmatchedRoute(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name         string
+		Req          *http.Request
+		ExpName      string
+		ExpUnmatched string
+		ExpRoute     string
+	}{
+		{
+			Name:         "Not Found",
+			Req:          httptest.NewRequest("GET", "/unknown", nil),
+			ExpName:      "HTTP GET",
+			ExpUnmatched: otelchi.UnmatchedRouteNotFound,
+		},
+		{
+			Name:         "Method Not Allowed",
+			Req:          httptest.NewRequest("POST", "/user/123", nil),
+			ExpName:      "HTTP POST",
+			ExpUnmatched: otelchi.UnmatchedRouteMethodNotAllowed,
+		},
+		{
+			Name:         "Not Found In Mounted Router",
+			Req:          httptest.NewRequest("GET", "/api/unknown", nil),
+			ExpName:      "HTTP GET",
+			ExpUnmatched: otelchi.UnmatchedRouteNotFound,
+		},
+		{
+			Name:     "Matched In Mounted Router",
+			Req:      httptest.NewRequest("GET", "/api/books", nil),
+			ExpName:  "/api/books",
+			ExpRoute: "/api/books",
+		},
+		{
+			Name:     "Root Route",
+			Req:      httptest.NewRequest("GET", "/", nil),
+			ExpName:  "/",
+			ExpRoute: "/",
+		},
+		{
+			Name:     "Escaped Slash In Path",
+			Req:      httptest.NewRequest("GET", "/user/a%2Fb", nil),
+			ExpName:  "/user/{id}",
+			ExpRoute: "/user/{id}",
+		},
+	}
+
+	// execute test cases with & without chi routes
+	for _, withChiRoutes := range []bool{false, true} {
+		for _, testCase := range testCases {
+			t.Run(fmt.Sprintf("%s With Chi Routes %v", testCase.Name, withChiRoutes), func(t *testing.T) {
+				router, sr := newSDKTestRouter("foobar", withChiRoutes)
+				router.HandleFunc("/", ok)
+				router.Get("/user/{id}", ok)
+				router.Route("/api", func(r chi.Router) {
+					r.Get("/books", ok)
+				})
+
+				executeRequests(router, []*http.Request{testCase.Req})
+
+				recordedSpans := sr.Ended()
+				require.Len(t, recordedSpans, 1)
+				span := recordedSpans[0]
+				assert.Equal(t, testCase.ExpName, span.Name())
+
+				attrs := map[attribute.Key]attribute.Value{}
+				for _, attr := range span.Attributes() {
+					attrs[attr.Key] = attr.Value
+				}
+				if testCase.ExpUnmatched != "" {
+					assert.Equal(t, testCase.ExpUnmatched, attrs[otelchi.UnmatchedRouteKey].AsString())
+					assert.NotContains(t, attrs, attribute.Key("http.route"))
+				} else {
+					assert.NotContains(t, attrs, otelchi.UnmatchedRouteKey)
+					assert.Equal(t, testCase.ExpRoute, attrs["http.route"].AsString())
+				}
+			})
+		}
+	}
+}
+
+func TestSDKIntegrationWithUnmatchedSpanName(t *testing.T) {
+	// prepare router and span recorder
+	router, sr := newSDKTestRouter(
+		"foobar",
+		false,
+		otelchi.WithUnmatchedSpanName(func(r *http.Request) string {
+			return "unmatched"
+		}),
+	)
+	router.Get("/user/{id}", ok)
+
+	// execute requests
+	executeRequests(router, []*http.Request{
+		httptest.NewRequest("GET", "/unknown", nil),
+		httptest.NewRequest("GET", "/user/123", nil),
+	})
+
+	// ensure only the unmatched request uses the configured name
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 2)
+	assert.Equal(t, "unmatched", recordedSpans[0].Name())
+	assert.Equal(t, "/user/{id}", recordedSpans[1].Name())
+}
+
+func TestSDKIntegrationUnmatchedWithSpanNameFormatter(t *testing.T) {
+	for _, withChiRoutes := range []bool{false, true} {
+		t.Run(fmt.Sprintf("With Chi Routes %v", withChiRoutes), func(t *testing.T) {
+			// prepare router and span recorder
+			router, sr := newSDKTestRouter(
+				"foobar",
+				withChiRoutes,
+				otelchi.WithSpanNameFormatter(func(r *http.Request, routePattern string) string {
+					if routePattern == "" {
+						return "foobar " + r.Method
+					}
+					return "foobar " + routePattern
+				}),
+			)
+			router.Get("/user/{id}", ok)
+
+			// execute requests
+			executeRequests(router, []*http.Request{
+				httptest.NewRequest("GET", "/unknown", nil),
+				httptest.NewRequest("POST", "/user/123", nil),
+				httptest.NewRequest("GET", "/user/123", nil),
+			})
+
+			// ensure the formatter is used for the unmatched requests as well
+			recordedSpans := sr.Ended()
+			require.Len(t, recordedSpans, 3)
+			assert.Equal(t, "foobar GET", recordedSpans[0].Name())
+			assert.Equal(t, "foobar POST", recordedSpans[1].Name())
+			assert.Equal(t, "foobar /user/{id}", recordedSpans[2].Name())
+		})
+	}
+}
+
+func TestSDKIntegrationAbortedRequest(t *testing.T) {
+	// prepare test cases
+	testCases := []struct {
+		Name       string
+		Options    []otelchi.Option
+		Cancel     bool
+		ExpAborted string
+		ExpCode    int
+		ExpStatus  codes.Code
+	}{
+		{
+			Name:       "Client Cancelled",
+			Cancel:     true,
+			ExpAborted: otelchi.AbortedClientCancelled,
+			ExpCode:    http.StatusOK,
+			ExpStatus:  codes.Unset,
+		},
+		{
+			Name: "Client Cancelled With Status Code",
+			Options: []otelchi.Option{
+				otelchi.WithAbortedStatusCodes(499, 0),
+				otelchi.WithStatusClassifier(otelchi.UnsetStatusClassifier(otelchi.DefaultStatusClassifier, 499)),
+			},
+			Cancel:     true,
+			ExpAborted: otelchi.AbortedClientCancelled,
+			ExpCode:    499,
+			ExpStatus:  codes.Unset,
+		},
+		{
+			Name:       "Deadline Exceeded",
+			ExpAborted: otelchi.AbortedDeadlineExceeded,
+			ExpCode:    http.StatusOK,
+			ExpStatus:  codes.Unset,
+		},
+		{
+			Name:       "Deadline Exceeded With Status Code",
+			Options:    []otelchi.Option{otelchi.WithAbortedStatusCodes(499, http.StatusGatewayTimeout)},
+			ExpAborted: otelchi.AbortedDeadlineExceeded,
+			ExpCode:    http.StatusGatewayTimeout,
+			ExpStatus:  codes.Error,
+		},
+	}
+
+	// execute test cases
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			spanRecorder := tracetest.NewSpanRecorder()
+			tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+			tracerProvider.RegisterSpanProcessor(spanRecorder)
+
+			// the timeout middleware is registered before the tracing
+			// middleware so the deadline is visible to the latter
+			router := chi.NewRouter()
+			router.Use(middleware.Timeout(10 * time.Millisecond))
+			router.Use(otelchi.Middleware("foobar", append(testCase.Options, otelchi.WithTracerProvider(tracerProvider))...))
+			router.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+				// wait until the request is aborted, the handler still
+				// writes the response as many handlers do
+				<-r.Context().Done()
+				w.WriteHeader(http.StatusOK)
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if testCase.Cancel {
+				cancel()
+			}
+			req := httptest.NewRequest("GET", "/test", nil).WithContext(ctx)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			recordedSpans := spanRecorder.Ended()
+			require.Len(t, recordedSpans, 1)
+			span := recordedSpans[0]
+			assert.Equal(t, testCase.ExpStatus, span.Status().Code)
+			assert.Contains(t, span.Attributes(), attribute.String("http.request.aborted", testCase.ExpAborted))
+			assert.Contains(t, span.Attributes(), attribute.Int("http.status_code", testCase.ExpCode))
+
+			require.Len(t, span.Events(), 1)
+			event := span.Events()[0]
+			assert.Equal(t, "request_aborted", event.Name)
+			assert.Contains(t, event.Attributes, attribute.String("http.request.aborted", testCase.ExpAborted))
+
+			var elapsed float64
+			for _, attr := range event.Attributes {
+				if attr.Key == otelchi.AbortedElapsedKey {
+					elapsed = attr.Value.AsFloat64()
+				}
+			}
+			if !testCase.Cancel {
+				assert.GreaterOrEqual(t, elapsed, (10 * time.Millisecond).Seconds())
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationInformationalResponse(t *testing.T) {
+	// prepare router and span recorder
+	router, sr := newSDKTestRouter("foobar", true, otelchi.WithSemConvStabilityMode(otelchi.SemConvStabilityModeStable))
+	router.Get("/hints", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Link", "</script.js>; rel=preload; as=script")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// collect the informational responses received by the client
+	informational := []int{}
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			return nil
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/hints", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// the informational responses are passed through to the client
+	assert.Equal(t, []int{http.StatusEarlyHints, http.StatusEarlyHints}, informational)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "created", string(body))
+
+	// the final status is recorded on the span
+	recordedSpans := sr.Ended()
+	require.Len(t, recordedSpans, 1)
+	assertSpan(
+		t,
+		recordedSpans[0],
+		"/hints",
+		trace.SpanKindServer,
+		codes.Unset,
+		attribute.Int("http.response.status_code", http.StatusCreated),
+		attribute.Int("http.response.body.size", 7),
+	)
+
+	// every informational response is recorded as an event
+	events := recordedSpans[0].Events()
+	require.Len(t, events, 2)
+	for _, event := range events {
+		assert.Equal(t, "informational_response", event.Name)
+		assert.Equal(t, []attribute.KeyValue{attribute.Int("http.response.status_code", http.StatusEarlyHints)}, event.Attributes)
+	}
+}